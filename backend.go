@@ -0,0 +1,280 @@
+package claudecodeproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Backend names used in ModelRoute.Backend.
+const (
+	BackendOpenAI    = "openai"
+	BackendAnthropic = "anthropic"
+)
+
+// Anthropic API defaults used by AnthropicBackend.
+const (
+	AnthropicURL     = "https://api.anthropic.com"
+	AnthropicVersion = "2023-06-01"
+)
+
+// defaultAnthropicMaxTokens is sent to the Messages API, which requires max_tokens, for requests
+// converted from OpenAI clients that set none.
+const defaultAnthropicMaxTokens = 4096
+
+// BackendRequest is a Claude Messages request on its way upstream.
+type BackendRequest struct {
+	// Claude is the decoded request.
+	Claude ClaudeMessagesRequest
+	// Body is the request body as the client sent it, when the client speaks the Messages API.
+	// Backends that speak it too forward Body, so fields the proxy does not know survive.
+	Body []byte
+	// Header holds the client's request headers.
+	Header http.Header
+	// Route is the routing table entry selected for the request.
+	Route ModelRoute
+	// ResponseModel is the model name reported back to the client.
+	ResponseModel string
+}
+
+// Backend sends Claude Messages requests to an upstream API.
+type Backend interface {
+	// Messages sends req upstream and returns the response as a stream of Claude SSE events,
+	// whether or not the client asked for streaming. Failures before the response starts are
+	// returned as *APIError, later ones as error events. Cancelling ctx aborts the request.
+	Messages(ctx context.Context, req BackendRequest) (io.ReadCloser, error)
+}
+
+type upstreamAuthKey struct{}
+
+// WithUpstreamAuth returns a context whose requests to an OpenAIBackend use auth instead of the
+// backend's Auth, e.g. the upstream credential of a client key.
+func WithUpstreamAuth(ctx context.Context, auth Authenticator) context.Context {
+	return context.WithValue(ctx, upstreamAuthKey{}, auth)
+}
+
+type anthropicKeyKey struct{}
+
+// WithAnthropicAPIKey returns a context whose requests to an AnthropicBackend use key instead of
+// the backend's APIKey.
+func WithAnthropicAPIKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, anthropicKeyKey{}, key)
+}
+
+// OpenAIBackend talks to an OpenAI-compatible chat completions API such as Copilot, converting
+// requests with ConvertClaudeToOAIWithOptions and responses with ConvertOAIStreamToClaudeStreamWithOptions.
+type OpenAIBackend struct {
+	Client *http.Client
+	// Auth adds the upstream credentials, unless the context carries others from WithUpstreamAuth.
+	Auth Authenticator
+	// URL is the API base URL. Empty uses the API base advertised by a CopilotAuth.
+	URL     string
+	Options ConvertOptions
+}
+
+// auth returns the Authenticator for requests made with ctx.
+func (b *OpenAIBackend) auth(ctx context.Context) Authenticator {
+	if auth, ok := ctx.Value(upstreamAuthKey{}).(Authenticator); ok {
+		return auth
+	}
+	return b.Auth
+}
+
+// BaseURL returns the API base URL, fetching a Copilot token first if the base comes from it.
+func (b *OpenAIBackend) BaseURL(ctx context.Context) (string, error) {
+	if b.URL != "" {
+		return b.URL, nil
+	}
+	if copilot, ok := b.auth(ctx).(*CopilotAuth); ok {
+		if _, err := copilot.Token(ctx); err != nil {
+			return "", err
+		}
+		return copilot.APIBase(), nil
+	}
+	return "", NewAPIError(http.StatusInternalServerError, "no upstream URL configured")
+}
+
+// Messages implements Backend.
+func (b *OpenAIBackend) Messages(ctx context.Context, req BackendRequest) (io.ReadCloser, error) {
+	_, body, err := openAIRequest(ctx, req, b.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := b.BaseURL(ctx)
+	if err != nil {
+		return nil, NewAPIError(http.StatusBadGateway, "upstream auth error: %v", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, NewAPIError(http.StatusInternalServerError, "request error: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if auth := b.auth(ctx); auth != nil {
+		if err := auth.Authorize(ctx, httpReq); err != nil {
+			return nil, NewAPIError(http.StatusBadGateway, "upstream auth error: %v", err)
+		}
+	}
+	resp, err := doUpstream(b.Client, httpReq)
+	if err != nil {
+		return nil, err
+	}
+	return claudeEvents(ctx, resp.Body, req, b.Options), nil
+}
+
+// openAIRequest converts req to the streaming OpenAI request for its route and records it. It
+// returns the request and its JSON body.
+func openAIRequest(ctx context.Context, req BackendRequest, opts ConvertOptions) (OAIRequest, []byte, error) {
+	opts.Logger = LoggerFrom(ctx)
+	oaiReq, err := ConvertClaudeToOAIWithOptions(req.Claude, opts)
+	if err != nil {
+		return OAIRequest{}, nil, NewAPIError(http.StatusBadRequest, "conversion error: %v", err)
+	}
+	req.Route.Apply(&oaiReq)
+	// Always stream from the upstream; non-streaming clients get the buffered events.
+	oaiReq.Stream = true
+	body, err := json.Marshal(oaiReq)
+	if err != nil {
+		return OAIRequest{}, nil, NewAPIError(http.StatusInternalServerError, "marshal error: %v", err)
+	}
+	RecordingFrom(ctx).SetOAIRequest(body)
+	return oaiReq, body, nil
+}
+
+// claudeEvents returns the Claude events of the OpenAI stream upstream, which is recorded,
+// converted in the background and closed once converted.
+func claudeEvents(ctx context.Context, upstream io.ReadCloser, req BackendRequest, opts ConvertOptions) io.ReadCloser {
+	opts.Logger = LoggerFrom(ctx)
+	upstream = RecordingFrom(ctx).TeeUpstream(upstream)
+	events, w := io.Pipe()
+	go func() {
+		defer upstream.Close()
+		w.CloseWithError(ConvertOAIStreamToClaudeStreamWithOptions(ctx, upstream, w, req.ResponseModel, opts))
+	}()
+	return events
+}
+
+// Models lists the upstream's models from its /models endpoint.
+func (b *OpenAIBackend) Models(ctx context.Context) ([]OAIModel, error) {
+	base, err := b.BaseURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth := b.auth(ctx); auth != nil {
+		if err := auth.Authorize(ctx, httpReq); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := doUpstream(b.Client, httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var list OAIModelList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decode model list: %w", err)
+	}
+	return list.Data, nil
+}
+
+// AnthropicBackend forwards requests to the Anthropic Messages API. The client's body is sent
+// as is, except that the route's upstream model replaces the model when set and streaming is
+// always requested. The client's credentials are replaced with APIKey, or the key of
+// WithAnthropicAPIKey.
+type AnthropicBackend struct {
+	Client *http.Client
+	// URL is the API base URL. Empty uses AnthropicURL.
+	URL    string
+	APIKey string
+	// Version is the anthropic-version sent when the client sends none. Empty uses AnthropicVersion.
+	Version string
+}
+
+// Messages implements Backend.
+func (b *AnthropicBackend) Messages(ctx context.Context, req BackendRequest) (io.ReadCloser, error) {
+	body := req.Body
+	if body == nil {
+		claudeReq := req.Claude
+		if claudeReq.MaxTokens <= 0 {
+			claudeReq.MaxTokens = defaultAnthropicMaxTokens
+		}
+		var err error
+		if body, err = json.Marshal(claudeReq); err != nil {
+			return nil, NewAPIError(http.StatusInternalServerError, "marshal error: %v", err)
+		}
+	}
+	// Only the top-level fields we change are decoded; everything else is passed through.
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, NewAPIError(http.StatusBadRequest, "invalid JSON: %v", err)
+	}
+	fields["stream"] = json.RawMessage("true")
+	if req.Route.Upstream != "" {
+		fields["model"], _ = json.Marshal(req.Route.Upstream)
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return nil, NewAPIError(http.StatusInternalServerError, "marshal error: %v", err)
+	}
+
+	base := b.URL
+	if base == "" {
+		base = AnthropicURL
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(base, "/")+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, NewAPIError(http.StatusInternalServerError, "request error: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "text/event-stream")
+	apiKey := b.APIKey
+	if key, ok := ctx.Value(anthropicKeyKey{}).(string); ok {
+		apiKey = key
+	}
+	httpReq.Header.Set("x-api-key", apiKey)
+	version := req.Header.Get("anthropic-version")
+	if version == "" {
+		version = b.Version
+	}
+	if version == "" {
+		version = AnthropicVersion
+	}
+	httpReq.Header.Set("anthropic-version", version)
+	for _, beta := range req.Header.Values("anthropic-beta") {
+		httpReq.Header.Add("anthropic-beta", beta)
+	}
+	resp, err := doUpstream(b.Client, httpReq)
+	if err != nil {
+		return nil, err
+	}
+	return RecordingFrom(ctx).TeeUpstream(resp.Body), nil
+}
+
+// doUpstream sends req and returns the response if it succeeded. Failures are returned as
+// *APIError carrying the upstream status and Retry-After.
+func doUpstream(client *http.Client, req *http.Request) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, NewAPIError(http.StatusBadGateway, "proxy error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		LoggerFrom(req.Context()).Warn("Upstream returned an error", "status", resp.StatusCode, "body", string(body))
+		apiErr := NewUpstreamError(resp.StatusCode, body)
+		apiErr.RetryAfter = resp.Header.Get("Retry-After")
+		return nil, apiErr
+	}
+	return resp, nil
+}
@@ -0,0 +1,184 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const anthropicStream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":5,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":2}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func TestAnthropicBackend_Messages(t *testing.T) {
+	var gotPath string
+	var gotHeader http.Header
+	var gotBody map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotHeader = r.Header
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, anthropicStream)
+	}))
+	defer srv.Close()
+
+	backend := &AnthropicBackend{URL: srv.URL, APIKey: "sk-ant-test"}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer client-key")
+	header.Set("anthropic-beta", "interleaved-thinking-2025-05-14")
+	events, err := backend.Messages(context.Background(), BackendRequest{
+		Body:   []byte(`{"model":"claude-sonnet-4","max_tokens":64,"messages":[{"role":"user","content":"Hi"}],"container":"c1"}`),
+		Header: header,
+		Route:  ModelRoute{Upstream: "claude-sonnet-4-20250514", Backend: BackendAnthropic},
+	})
+	if err != nil {
+		t.Fatalf("Messages error: %v", err)
+	}
+	defer events.Close()
+	resp, err := ParseClaudeStreamToResponse(events)
+	if err != nil {
+		t.Fatalf("ParseClaudeStreamToResponse error: %v", err)
+	}
+	if resp.ID != "msg_1" || len(resp.Content) != 1 {
+		t.Errorf("Response mismatch: %+v", resp)
+	}
+
+	if gotPath != "/v1/messages" {
+		t.Errorf("Path mismatch: got %q", gotPath)
+	}
+	if gotHeader.Get("x-api-key") != "sk-ant-test" || gotHeader.Get("Authorization") != "" {
+		t.Errorf("Credentials mismatch: x-api-key %q, Authorization %q", gotHeader.Get("x-api-key"), gotHeader.Get("Authorization"))
+	}
+	if gotHeader.Get("anthropic-version") != AnthropicVersion || gotHeader.Get("anthropic-beta") != "interleaved-thinking-2025-05-14" {
+		t.Errorf("Anthropic headers mismatch: %v", gotHeader)
+	}
+	// Unknown fields pass through; the route's model and streaming are forced.
+	if gotBody["container"] != "c1" || gotBody["model"] != "claude-sonnet-4-20250514" || gotBody["stream"] != true {
+		t.Errorf("Body mismatch: %v", gotBody)
+	}
+}
+
+func TestAnthropicBackend_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(529)
+		io.WriteString(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	}))
+	defer srv.Close()
+
+	backend := &AnthropicBackend{URL: srv.URL, APIKey: "sk-ant-test"}
+	_, err := backend.Messages(context.Background(), BackendRequest{
+		Claude: ClaudeMessagesRequest{Model: "claude-sonnet-4", MaxTokens: 64},
+		Header: http.Header{},
+	})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Status != 529 || apiErr.Message != "Overloaded" {
+		t.Errorf("Expected the upstream error, got %v", err)
+	}
+}
+
+func TestAnthropicBackend_DefaultMaxTokens(t *testing.T) {
+	var gotBody map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, anthropicStream)
+	}))
+	defer srv.Close()
+
+	// Requests converted from OpenAI clients have no body and may lack max_tokens.
+	backend := &AnthropicBackend{URL: srv.URL, APIKey: "sk-ant-test"}
+	events, err := backend.Messages(context.Background(), BackendRequest{
+		Claude: ClaudeMessagesRequest{Model: "claude-sonnet-4", Messages: []ClaudeMessage{{Role: "user", Content: "Hi"}}},
+		Header: http.Header{},
+	})
+	if err != nil {
+		t.Fatalf("Messages error: %v", err)
+	}
+	io.Copy(io.Discard, events)
+	events.Close()
+	if gotBody["max_tokens"] != float64(defaultAnthropicMaxTokens) {
+		t.Errorf("max_tokens mismatch: got %v, want %d", gotBody["max_tokens"], defaultAnthropicMaxTokens)
+	}
+}
+
+func TestOpenAIBackend_Messages(t *testing.T) {
+	var gotReq OAIRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&gotReq)
+		io.WriteString(w, oaiChunks("stop", `{"content":"Hello"}`))
+	}))
+	defer srv.Close()
+
+	backend := &OpenAIBackend{URL: srv.URL, Auth: StaticKey("test-key")}
+	events, err := backend.Messages(context.Background(), BackendRequest{
+		Claude: ClaudeMessagesRequest{
+			Model:     "claude-3-haiku",
+			MaxTokens: 64,
+			Messages:  []ClaudeMessage{{Role: "user", Content: "Hi"}},
+		},
+		Route:         ModelRoute{Upstream: "gpt-4o-mini"},
+		ResponseModel: "claude-3-haiku",
+	})
+	if err != nil {
+		t.Fatalf("Messages error: %v", err)
+	}
+	defer events.Close()
+	b, _ := io.ReadAll(events)
+	stream := string(b)
+	if !strings.Contains(stream, "event: message_start") || !strings.Contains(stream, `"text":"Hello"`) || !strings.Contains(stream, `"model":"claude-3-haiku"`) {
+		t.Errorf("Unexpected Claude stream: %s", stream)
+	}
+	if gotReq.Model != "gpt-4o-mini" || !gotReq.Stream {
+		t.Errorf("Upstream request mismatch: model %q, stream %v", gotReq.Model, gotReq.Stream)
+	}
+}
+
+func TestOpenAIBackend_UpstreamAuthFromContext(t *testing.T) {
+	var gotAuth []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = append(gotAuth, r.Header.Get("Authorization"))
+		io.WriteString(w, oaiChunks("stop", `{"content":"Hello"}`))
+	}))
+	defer srv.Close()
+
+	backend := &OpenAIBackend{URL: srv.URL, Auth: StaticKey("proxy-key")}
+	req := BackendRequest{
+		Claude: ClaudeMessagesRequest{Model: "claude-3-haiku", MaxTokens: 64, Messages: []ClaudeMessage{{Role: "user", Content: "Hi"}}},
+		Route:  ModelRoute{Upstream: "gpt-4o-mini"},
+	}
+	for _, ctx := range []context.Context{context.Background(), WithUpstreamAuth(context.Background(), StaticKey("user-key"))} {
+		events, err := backend.Messages(ctx, req)
+		if err != nil {
+			t.Fatalf("Messages error: %v", err)
+		}
+		io.ReadAll(events)
+		events.Close()
+	}
+	want := []string{"Bearer proxy-key", "Bearer user-key"}
+	if !reflect.DeepEqual(gotAuth, want) {
+		t.Errorf("Upstream auth mismatch: got %q, want %q", gotAuth, want)
+	}
+}
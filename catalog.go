@@ -0,0 +1,166 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ModelCatalog lists the models clients can ask for: the Claude names and upstream models of the
+// routing table, followed by the models the upstream reports. The upstream list is cached for TTL,
+// separately for every upstream credential set with WithUpstreamAuth.
+type ModelCatalog struct {
+	// Upstream fetches the upstream's models. Nil lists the routing table only.
+	Upstream func(ctx context.Context) ([]OAIModel, error)
+	Routing  RoutingConfig
+	// TTL is how long the upstream list is cached. Zero fetches it on every call.
+	TTL time.Duration
+
+	mu sync.Mutex
+	// cache holds the upstream lists by credential, nil for the default one.
+	cache map[Authenticator]*cachedModels
+	now   func() time.Time
+}
+
+type cachedModels struct {
+	models  []OAIModel
+	expires time.Time
+}
+
+// Models returns the model list. When the upstream cannot be queried, the last list it returned
+// is used, or none at all.
+func (c *ModelCatalog) Models(ctx context.Context) []ClaudeModel {
+	models, _ := c.models(ctx)
+	return models
+}
+
+// Model returns the model with the given ID. Names the routing table matches by pattern are
+// found even though they are not listed.
+func (c *ModelCatalog) Model(ctx context.Context, id string) (ClaudeModel, bool) {
+	models, byID := c.models(ctx)
+	for _, m := range models {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	if c.Routing.Matches(id) {
+		return c.claudeModel(id, byID), true
+	}
+	return ClaudeModel{}, false
+}
+
+// models returns the model list and the upstream models by ID.
+func (c *ModelCatalog) models(ctx context.Context) ([]ClaudeModel, map[string]OAIModel) {
+	upstream := c.upstreamModels(ctx)
+	byID := make(map[string]OAIModel, len(upstream))
+	for _, m := range upstream {
+		byID[m.ID] = m
+	}
+
+	var models []ClaudeModel
+	seen := map[string]bool{}
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			models = append(models, c.claudeModel(id, byID))
+		}
+	}
+	for _, id := range c.Routing.ModelNames() {
+		add(id)
+	}
+	for _, m := range upstream {
+		add(m.ID)
+	}
+	return models, byID
+}
+
+// claudeModel describes id using the upstream model of that name or, for Claude names, the
+// upstream model they are routed to. The creation time stays zero when neither is known.
+func (c *ModelCatalog) claudeModel(id string, byID map[string]OAIModel) ClaudeModel {
+	model := ClaudeModel{Type: "model", ID: id, DisplayName: id}
+	m, ok := byID[id]
+	if ok && m.Name != "" {
+		model.DisplayName = m.Name
+	}
+	if !ok {
+		m = byID[c.Routing.Route(id).Upstream]
+	}
+	if m.Created != 0 {
+		model.CreatedAt = time.Unix(m.Created, 0).UTC()
+	}
+	return model
+}
+
+// upstreamModels returns the upstream's models for the credential of ctx. The lock is not held
+// while fetching, so a slow upstream does not hold up requests served from the cache.
+func (c *ModelCatalog) upstreamModels(ctx context.Context) []OAIModel {
+	if c.Upstream == nil {
+		return nil
+	}
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+	auth, _ := ctx.Value(upstreamAuthKey{}).(Authenticator)
+	c.mu.Lock()
+	cached := c.cache[auth]
+	c.mu.Unlock()
+	if cached != nil && now().Before(cached.expires) {
+		return cached.models
+	}
+	models, err := c.Upstream(ctx)
+	if err != nil {
+		LoggerFrom(ctx).Warn("Failed to list upstream models", "error", err)
+		if cached != nil {
+			return cached.models
+		}
+		return nil
+	}
+	if models == nil {
+		models = []OAIModel{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil {
+		c.cache = map[Authenticator]*cachedModels{}
+	}
+	c.cache[auth] = &cachedModels{models: models, expires: now().Add(c.TTL)}
+	return models
+}
+
+// PageModels returns the page of models following afterID, or preceding beforeID, with at most
+// limit entries, as listed by /v1/models. A limit outside 1-1000 uses 20.
+func PageModels(models []ClaudeModel, limit int, afterID, beforeID string) ClaudeModelList {
+	if limit < 1 || limit > 1000 {
+		limit = 20
+	}
+	start, end := 0, len(models)
+	for i, m := range models {
+		if afterID != "" && m.ID == afterID {
+			start = i + 1
+		}
+		if beforeID != "" && m.ID == beforeID {
+			end = i
+		}
+	}
+	if start > end {
+		start = end
+	}
+	page := models[start:end]
+	hasMore := false
+	if len(page) > limit {
+		hasMore = true
+		if beforeID != "" && afterID == "" {
+			// Paging backwards returns the entries closest to beforeID.
+			page = page[len(page)-limit:]
+		} else {
+			page = page[:limit]
+		}
+	}
+	list := ClaudeModelList{Data: append([]ClaudeModel{}, page...), HasMore: hasMore}
+	if len(page) > 0 {
+		list.FirstID = &page[0].ID
+		list.LastID = &page[len(page)-1].ID
+	}
+	return list
+}
@@ -0,0 +1,171 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestModelCatalog_Models(t *testing.T) {
+	calls := 0
+	var fail bool
+	now := time.Unix(1000, 0)
+	catalog := &ModelCatalog{
+		Upstream: func(ctx context.Context) ([]OAIModel, error) {
+			calls++
+			if fail {
+				return nil, errors.New("unavailable")
+			}
+			return []OAIModel{
+				{ID: "gpt-4.1", Name: "GPT-4.1", Created: 1700000000},
+				{ID: "claude-sonnet-4", Name: "Claude Sonnet 4"},
+			}, nil
+		},
+		Routing: RoutingConfig{
+			Routes:       []ModelRoute{{Match: "claude-3-7-sonnet-20250219", Upstream: "claude-sonnet-4"}, {Match: "*haiku*", Upstream: "gpt-4.1"}},
+			DefaultModel: "gpt-4.1",
+		},
+		TTL: time.Minute,
+		now: func() time.Time { return now },
+	}
+
+	var ids []string
+	for _, m := range catalog.Models(context.Background()) {
+		ids = append(ids, m.ID)
+	}
+	// Claude aliases come first, then the upstream models without duplicates.
+	if want := []string{"claude-3-7-sonnet-20250219", "gpt-4.1", "claude-sonnet-4"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Model IDs mismatch: got %v, want %v", ids, want)
+	}
+	m, ok := catalog.Model(context.Background(), "gpt-4.1")
+	if !ok || m.DisplayName != "GPT-4.1" || m.Type != "model" || !m.CreatedAt.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Model mismatch: got %+v", m)
+	}
+	// Claude names take the creation time of their upstream model, if it reports one.
+	if m, ok := catalog.Model(context.Background(), "claude-3-5-haiku-20241022"); !ok || !m.CreatedAt.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("Expected a model matched by a route pattern to be found, got %+v", m)
+	}
+	if m, _ := catalog.Model(context.Background(), "claude-3-7-sonnet-20250219"); !m.CreatedAt.IsZero() {
+		t.Errorf("Expected no creation time without an upstream one, got %v", m.CreatedAt)
+	}
+	if _, ok := catalog.Model(context.Background(), "davinci"); ok {
+		t.Error("Expected an unknown model not to be found")
+	}
+	if calls != 1 {
+		t.Errorf("Expected the upstream list to be cached, got %d calls", calls)
+	}
+
+	// After the TTL the list is fetched again; on failure the stale list is kept.
+	now = now.Add(2 * time.Minute)
+	fail = true
+	if got := len(catalog.Models(context.Background())); got != 3 || calls != 2 {
+		t.Errorf("Expected the stale list after a failed refresh, got %d models after %d calls", got, calls)
+	}
+}
+
+func TestModelCatalog_ModelsPerCredential(t *testing.T) {
+	catalog := &ModelCatalog{
+		Upstream: func(ctx context.Context) ([]OAIModel, error) {
+			if _, ok := ctx.Value(upstreamAuthKey{}).(Authenticator); ok {
+				return []OAIModel{{ID: "own-model"}}, nil
+			}
+			return []OAIModel{{ID: "shared-model"}}, nil
+		},
+		Routing: RoutingConfig{DefaultModel: "gpt-4.1"},
+		TTL:     time.Minute,
+	}
+	own := WithUpstreamAuth(context.Background(), StaticKey("alice-upstream"))
+	for _, tt := range []struct {
+		ctx  context.Context
+		want string
+	}{
+		{context.Background(), "shared-model"},
+		{own, "own-model"},
+		{context.Background(), "shared-model"},
+	} {
+		if _, ok := catalog.Model(tt.ctx, tt.want); !ok {
+			t.Errorf("Expected %s in the list of its credential", tt.want)
+		}
+	}
+	if _, ok := catalog.Model(own, "shared-model"); ok {
+		t.Error("Expected the shared list not to be served for another credential")
+	}
+}
+
+func TestModelCatalog_SlowUpstream(t *testing.T) {
+	release := make(chan struct{})
+	catalog := &ModelCatalog{
+		Upstream: func(ctx context.Context) ([]OAIModel, error) {
+			if _, ok := ctx.Value(upstreamAuthKey{}).(Authenticator); ok {
+				<-release
+			}
+			return []OAIModel{{ID: "gpt-4.1"}}, nil
+		},
+		Routing: RoutingConfig{DefaultModel: "gpt-4.1"},
+		TTL:     time.Minute,
+	}
+	catalog.Models(context.Background())
+
+	// While one credential's list is being fetched, cached lists are still served.
+	fetched := make(chan struct{})
+	go func() {
+		catalog.Models(WithUpstreamAuth(context.Background(), StaticKey("slow")))
+		close(fetched)
+	}()
+	done := make(chan struct{})
+	go func() {
+		catalog.Models(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Cached models were blocked by an upstream fetch")
+	}
+	close(release)
+	<-fetched
+}
+
+func TestPageModels(t *testing.T) {
+	var models []ClaudeModel
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		models = append(models, ClaudeModel{Type: "model", ID: id})
+	}
+	tests := []struct {
+		name                string
+		limit               int
+		afterID             string
+		beforeID            string
+		wantIDs             []string
+		wantHasMore         bool
+		wantFirst, wantLast string
+	}{
+		{"All", 0, "", "", []string{"a", "b", "c", "d", "e"}, false, "a", "e"},
+		{"FirstPage", 2, "", "", []string{"a", "b"}, true, "a", "b"},
+		{"AfterID", 2, "b", "", []string{"c", "d"}, true, "c", "d"},
+		{"LastPage", 2, "c", "", []string{"d", "e"}, false, "d", "e"},
+		{"BeforeID", 2, "", "d", []string{"b", "c"}, true, "b", "c"},
+		{"Empty", 2, "e", "", nil, false, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list := PageModels(models, tt.limit, tt.afterID, tt.beforeID)
+			var ids []string
+			for _, m := range list.Data {
+				ids = append(ids, m.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) || list.HasMore != tt.wantHasMore {
+				t.Errorf("Page mismatch: got %v (has_more %v), want %v (has_more %v)", ids, list.HasMore, tt.wantIDs, tt.wantHasMore)
+			}
+			var first, last string
+			if list.FirstID != nil {
+				first, last = *list.FirstID, *list.LastID
+			}
+			if first != tt.wantFirst || last != tt.wantLast {
+				t.Errorf("first_id/last_id mismatch: got %q/%q, want %q/%q", first, last, tt.wantFirst, tt.wantLast)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// ClientKey is an API key clients authenticate to the proxy with.
+type ClientKey struct {
+	Key string `json:"key"`
+	// User names the key's owner in logs.
+	User string `json:"user"`
+	// Models restricts the key to these Claude model names, given as exact names or glob
+	// patterns like ModelRoute.Match. Empty allows every model.
+	Models []string `json:"models,omitempty"`
+	// UpstreamAPIKey replaces the proxy's upstream credential for this key's requests: an API
+	// key for an OpenAI-compatible upstream or a GitHub OAuth token for Copilot.
+	UpstreamAPIKey string `json:"upstream_api_key,omitempty"`
+	// AnthropicAPIKey replaces the proxy's Anthropic API key for this key's requests to routes
+	// with the anthropic backend.
+	AnthropicAPIKey string `json:"anthropic_api_key,omitempty"`
+}
+
+// AllowsModel reports whether the key may use model.
+func (k ClientKey) AllowsModel(model string) bool {
+	if len(k.Models) == 0 {
+		return true
+	}
+	for _, pattern := range k.Models {
+		if ok, _ := path.Match(pattern, model); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientKeys is the set of keys clients may authenticate with.
+type ClientKeys struct {
+	Keys []ClientKey `json:"keys"`
+
+	// byHash indexes Keys by the SHA-256 of the key, so lookups take no time that depends on
+	// how much of a guessed key is right.
+	byHash map[[sha256.Size]byte]int
+}
+
+// NewClientKeys returns the ClientKeys of keys. Every key needs a user and must be unique.
+func NewClientKeys(keys []ClientKey) (*ClientKeys, error) {
+	c := &ClientKeys{Keys: keys, byHash: map[[sha256.Size]byte]int{}}
+	for i, k := range keys {
+		if k.Key == "" || k.User == "" {
+			return nil, fmt.Errorf("key %d: key and user are required", i)
+		}
+		for _, pattern := range k.Models {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("key %d: bad model pattern %q: %w", i, pattern, err)
+			}
+		}
+		hash := sha256.Sum256([]byte(k.Key))
+		if j, ok := c.byHash[hash]; ok {
+			return nil, fmt.Errorf("key %d: same key as key %d", i, j)
+		}
+		c.byHash[hash] = i
+	}
+	return c, nil
+}
+
+// LoadClientKeys reads client keys from a JSON file of the form {"keys": [...]}.
+func LoadClientKeys(path string) (*ClientKeys, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file ClientKeys
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("parse client keys %s: %w", path, err)
+	}
+	keys, err := NewClientKeys(file.Keys)
+	if err != nil {
+		return nil, fmt.Errorf("client keys %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// Authenticate returns the key r authenticates with, sent as x-api-key or as a bearer token.
+// It returns an *APIError with status 401 when the key is missing or unknown.
+func (c *ClientKeys) Authenticate(r *http.Request) (ClientKey, error) {
+	key := r.Header.Get("X-Api-Key")
+	if key == "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			key = strings.TrimSpace(token)
+		}
+	}
+	if key == "" {
+		return ClientKey{}, NewAPIError(http.StatusUnauthorized, "missing API key: send it in the x-api-key header or as a bearer token")
+	}
+	i, ok := c.byHash[sha256.Sum256([]byte(key))]
+	if !ok {
+		return ClientKey{}, NewAPIError(http.StatusUnauthorized, "invalid x-api-key")
+	}
+	return c.Keys[i], nil
+}
+
+type clientKeyKey struct{}
+
+// WithClientKey returns a context carrying the key the request was authenticated with.
+func WithClientKey(ctx context.Context, key ClientKey) context.Context {
+	return context.WithValue(ctx, clientKeyKey{}, key)
+}
+
+// ClientKeyFrom returns the client key of ctx, if the request was authenticated.
+func ClientKeyFrom(ctx context.Context) (ClientKey, bool) {
+	key, ok := ctx.Value(clientKeyKey{}).(ClientKey)
+	return key, ok
+}
@@ -0,0 +1,100 @@
+package claudecodeproxy
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientKeys_Authenticate(t *testing.T) {
+	keys, err := NewClientKeys([]ClientKey{
+		{Key: "sk-alice", User: "alice"},
+		{Key: "sk-bob", User: "bob", Models: []string{"*haiku*"}},
+	})
+	if err != nil {
+		t.Fatalf("NewClientKeys error: %v", err)
+	}
+	tests := []struct {
+		name     string
+		header   http.Header
+		wantUser string
+	}{
+		{"XAPIKey", http.Header{"X-Api-Key": {"sk-alice"}}, "alice"},
+		{"Bearer", http.Header{"Authorization": {"Bearer sk-bob"}}, "bob"},
+		{"Missing", http.Header{}, ""},
+		{"Unknown", http.Header{"X-Api-Key": {"sk-carol"}}, ""},
+		{"NotBearer", http.Header{"Authorization": {"Basic sk-alice"}}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodPost, "/v1/messages", nil)
+			r.Header = tt.header
+			key, err := keys.Authenticate(r)
+			if tt.wantUser == "" {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized || apiErr.Type != "authentication_error" {
+					t.Errorf("Expected a 401 authentication_error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate error: %v", err)
+			}
+			if key.User != tt.wantUser {
+				t.Errorf("User mismatch: got %q, want %q", key.User, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestClientKey_AllowsModel(t *testing.T) {
+	key := ClientKey{Models: []string{"*haiku*", "claude-sonnet-4-20250514"}}
+	for model, want := range map[string]bool{
+		"claude-3-5-haiku-20241022": true,
+		"claude-sonnet-4-20250514":  true,
+		"claude-opus-4-20250514":    false,
+	} {
+		if got := key.AllowsModel(model); got != want {
+			t.Errorf("AllowsModel(%q) mismatch: got %v, want %v", model, got, want)
+		}
+	}
+	if !(ClientKey{}).AllowsModel("anything") {
+		t.Error("Expected a key without models to allow every model")
+	}
+}
+
+func TestLoadClientKeys(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{"Valid", `{"keys":[{"key":"sk-a","user":"alice","models":["*sonnet*"],"upstream_api_key":"up-a"}]}`, false},
+		{"MissingUser", `{"keys":[{"key":"sk-a"}]}`, true},
+		{"Duplicate", `{"keys":[{"key":"sk-a","user":"alice"},{"key":"sk-a","user":"bob"}]}`, true},
+		{"BadPattern", `{"keys":[{"key":"sk-a","user":"alice","models":["["]}]}`, true},
+		{"BadJSON", `{"keys":`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			os.WriteFile(path, []byte(tt.content), 0o600)
+			keys, err := LoadClientKeys(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadClientKeys error: %v", err)
+			}
+			if len(keys.Keys) != 1 || keys.Keys[0].UpstreamAPIKey != "up-a" || keys.Keys[0].Models[0] != "*sonnet*" {
+				t.Errorf("Keys mismatch: got %+v", keys.Keys)
+			}
+		})
+	}
+}
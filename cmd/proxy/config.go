@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	claudecodeproxy "claude-proxy"
+)
+
+// Duration is a time.Duration that reads and writes as a string such as "30s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// String and Set make *Duration a flag.Value.
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config holds the proxy settings. Values are resolved with the precedence
+// command-line flags > environment variables > config file > defaults.
+type Config struct {
+	ListenAddr string `json:"listen_addr"`
+	// UpstreamType is "openai" for an OpenAI-compatible API authenticated with APIKey as a
+	// bearer token, "copilot" to exchange APIKey (a GitHub OAuth token) for Copilot
+	// session tokens and talk to the Copilot API directly, or "replay" to serve the upstream
+	// streams recorded in ReplayFile without network access.
+	UpstreamType string `json:"upstream_type"`
+	// UpstreamURL defaults to OpenAIProxyURL for "openai" and to the endpoint advertised by
+	// the Copilot token for "copilot".
+	UpstreamURL string `json:"upstream_url"`
+	APIKey      string `json:"api_key"`
+	// CredentialsFile holds the GitHub token saved by "login". It is used when APIKey is unset.
+	CredentialsFile string `json:"credentials_file"`
+	// CopilotTokenURL is the Copilot token exchange endpoint.
+	CopilotTokenURL string `json:"copilot_token_url"`
+	// AnthropicURL and AnthropicAPIKey configure the Anthropic Messages API for routes with
+	// "backend": "anthropic". The backend is only available when the key is set.
+	AnthropicURL    string `json:"anthropic_url"`
+	AnthropicAPIKey string `json:"anthropic_api_key"`
+
+	// ConnectTimeout bounds dialing and the TLS handshake with the upstream.
+	ConnectTimeout Duration `json:"connect_timeout"`
+	// ResponseHeaderTimeout bounds the wait for upstream response headers. It does not
+	// limit how long a response may stream.
+	ResponseHeaderTimeout Duration `json:"response_header_timeout"`
+	// MaxRetries is how often a failed upstream request (connection error, 429 or 5xx) is
+	// retried before any response reaches the client; zero disables retries.
+	MaxRetries int `json:"max_retries"`
+	// RetryBaseDelay is the backoff before the first retry, doubled for each further retry
+	// up to RetryMaxDelay. Upstream Retry-After headers take precedence.
+	RetryBaseDelay Duration `json:"retry_base_delay"`
+	RetryMaxDelay  Duration `json:"retry_max_delay"`
+
+	// TLSCertFile and TLSKeyFile make the proxy serve HTTPS when both are set.
+	TLSCertFile string `json:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file"`
+	// UpstreamCAFile is a PEM bundle trusted in addition to the system roots.
+	UpstreamCAFile string `json:"upstream_ca_file"`
+	// UpstreamInsecureSkipVerify disables upstream certificate verification, for local stubs only.
+	UpstreamInsecureSkipVerify bool `json:"upstream_insecure_skip_verify"`
+
+	// MaxImageBytes limits the decoded size of base64 images; zero means no limit.
+	MaxImageBytes int `json:"max_image_bytes"`
+	// ImageMode is "reject" or "downscale" for images above MaxImageBytes.
+	ImageMode string `json:"image_mode"`
+
+	// ModelsCacheTTL is how long the upstream model list served by /v1/models is cached.
+	ModelsCacheTTL Duration `json:"models_cache_ttl"`
+
+	// ReplayFile is the JSONL recording replayed by the "replay" upstream type.
+	ReplayFile string `json:"replay_file"`
+	// ReplayMatch is "hash" to replay the recording with the same messages or "sequence" to
+	// replay recordings in order.
+	ReplayMatch string `json:"replay_match"`
+	// ReplayStrict fails requests no recording matches instead of replaying the next one.
+	ReplayStrict bool `json:"replay_strict"`
+
+	// RecordFile, when set, is a JSONL file every exchange is appended to for debugging.
+	RecordFile string `json:"record_file"`
+	// RecordMaxBodyBytes truncates recorded request bodies; zero records them in full.
+	RecordMaxBodyBytes int `json:"record_max_body_bytes"`
+
+	// LogLevel is "debug", "info", "warn" or "error"; LogFormat is "text" or "json".
+	LogLevel  string `json:"log_level"`
+	LogFormat string `json:"log_format"`
+
+	// ClientKeysFile lists the API keys clients must send to use the /v1 endpoints. Without
+	// it the proxy accepts every request.
+	ClientKeysFile string `json:"client_keys_file"`
+
+	TokenizerFile string `json:"tokenizer_file"`
+	RoutingConfig string `json:"routing_config"`
+	ModelRoutes   string `json:"model_routes"`
+}
+
+// DefaultConfig returns the built-in settings.
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:            ListenAddr,
+		UpstreamType:          "openai",
+		CopilotTokenURL:       claudecodeproxy.CopilotTokenURL,
+		AnthropicURL:          claudecodeproxy.AnthropicURL,
+		CredentialsFile:       defaultCredentialsFile(),
+		ConnectTimeout:        Duration(10 * time.Second),
+		ResponseHeaderTimeout: Duration(5 * time.Minute),
+		MaxRetries:            3,
+		RetryBaseDelay:        Duration(500 * time.Millisecond),
+		RetryMaxDelay:         Duration(30 * time.Second),
+		ImageMode:             claudecodeproxy.ImageModeReject,
+		ModelsCacheTTL:        Duration(5 * time.Minute),
+		ReplayMatch:           claudecodeproxy.ReplayMatchHash,
+		LogLevel:              "info",
+		LogFormat:             "text",
+	}
+}
+
+// configEnv maps environment variables to the flag of the same setting.
+var configEnv = []struct {
+	env  string
+	flag string
+}{
+	{"LISTEN_ADDR", "listen"},
+	{"UPSTREAM_TYPE", "upstream-type"},
+	{"UPSTREAM_URL", "upstream-url"},
+	{"COPILOT_API_KEY", "api-key"},
+	{"COPILOT_TOKEN_URL", "copilot-token-url"},
+	{"CREDENTIALS_FILE", "credentials-file"},
+	{"ANTHROPIC_UPSTREAM_URL", "anthropic-url"},
+	{"ANTHROPIC_API_KEY", "anthropic-api-key"},
+	{"UPSTREAM_CONNECT_TIMEOUT", "connect-timeout"},
+	{"UPSTREAM_RESPONSE_HEADER_TIMEOUT", "response-header-timeout"},
+	{"UPSTREAM_MAX_RETRIES", "max-retries"},
+	{"UPSTREAM_RETRY_BASE_DELAY", "retry-base-delay"},
+	{"UPSTREAM_RETRY_MAX_DELAY", "retry-max-delay"},
+	{"TLS_CERT_FILE", "tls-cert"},
+	{"TLS_KEY_FILE", "tls-key"},
+	{"UPSTREAM_CA_FILE", "upstream-ca"},
+	{"UPSTREAM_INSECURE_SKIP_VERIFY", "upstream-insecure"},
+	{"MAX_IMAGE_BYTES", "max-image-bytes"},
+	{"IMAGE_MODE", "image-mode"},
+	{"MODELS_CACHE_TTL", "models-cache-ttl"},
+	{"REPLAY_FILE", "replay"},
+	{"REPLAY_MATCH", "replay-match"},
+	{"REPLAY_STRICT", "replay-strict"},
+	{"RECORD_FILE", "record"},
+	{"RECORD_MAX_BODY_BYTES", "record-max-body-bytes"},
+	{"LOG_LEVEL", "log-level"},
+	{"LOG_FORMAT", "log-format"},
+	{"CLIENT_KEYS_FILE", "client-keys"},
+	{"TOKENIZER_FILE", "tokenizer"},
+	{"ROUTING_CONFIG", "routing-config"},
+	{"MODEL_ROUTES", "model-routes"},
+}
+
+// newFlagSet returns a flag set whose flags write into cfg.
+func newFlagSet(name string, cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.String("config", "", "path to a JSON config file (env PROXY_CONFIG)")
+	fs.StringVar(&cfg.ListenAddr, "listen", cfg.ListenAddr, "address to listen on")
+	fs.StringVar(&cfg.UpstreamType, "upstream-type", cfg.UpstreamType, "upstream type: openai, copilot or replay")
+	fs.StringVar(&cfg.UpstreamURL, "upstream-url", cfg.UpstreamURL, "base URL of the upstream API")
+	fs.StringVar(&cfg.APIKey, "api-key", cfg.APIKey, "upstream API key, or GitHub OAuth token for copilot")
+	fs.StringVar(&cfg.CredentialsFile, "credentials-file", cfg.CredentialsFile, "GitHub token file written by the login command")
+	fs.StringVar(&cfg.CopilotTokenURL, "copilot-token-url", cfg.CopilotTokenURL, "Copilot token exchange endpoint")
+	fs.StringVar(&cfg.AnthropicURL, "anthropic-url", cfg.AnthropicURL, "base URL of the Anthropic API for anthropic routes")
+	fs.StringVar(&cfg.AnthropicAPIKey, "anthropic-api-key", cfg.AnthropicAPIKey, "Anthropic API key, enables the anthropic backend")
+	fs.Var(&cfg.ConnectTimeout, "connect-timeout", "upstream connect timeout")
+	fs.Var(&cfg.ResponseHeaderTimeout, "response-header-timeout", "upstream response header timeout")
+	fs.IntVar(&cfg.MaxRetries, "max-retries", cfg.MaxRetries, "retries for failed upstream requests, 0 to disable")
+	fs.Var(&cfg.RetryBaseDelay, "retry-base-delay", "backoff before the first retry")
+	fs.Var(&cfg.RetryMaxDelay, "retry-max-delay", "longest backoff and Retry-After honoured")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", cfg.TLSCertFile, "TLS certificate file for serving HTTPS")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", cfg.TLSKeyFile, "TLS key file for serving HTTPS")
+	fs.StringVar(&cfg.UpstreamCAFile, "upstream-ca", cfg.UpstreamCAFile, "extra CA bundle for the upstream")
+	fs.BoolVar(&cfg.UpstreamInsecureSkipVerify, "upstream-insecure", cfg.UpstreamInsecureSkipVerify, "skip upstream TLS verification")
+	fs.IntVar(&cfg.MaxImageBytes, "max-image-bytes", cfg.MaxImageBytes, "largest image forwarded upstream in bytes, 0 for no limit")
+	fs.StringVar(&cfg.ImageMode, "image-mode", cfg.ImageMode, "what to do with larger images: reject or downscale")
+	fs.Var(&cfg.ModelsCacheTTL, "models-cache-ttl", "how long the upstream model list is cached")
+	fs.StringVar(&cfg.ReplayFile, "replay", cfg.ReplayFile, "JSONL recording served by the replay upstream type")
+	fs.StringVar(&cfg.ReplayMatch, "replay-match", cfg.ReplayMatch, "how requests find their recording: hash or sequence")
+	fs.BoolVar(&cfg.ReplayStrict, "replay-strict", cfg.ReplayStrict, "fail requests that match no recording")
+	fs.StringVar(&cfg.RecordFile, "record", cfg.RecordFile, "append every exchange to this JSONL file")
+	fs.IntVar(&cfg.RecordMaxBodyBytes, "record-max-body-bytes", cfg.RecordMaxBodyBytes, "truncate recorded request bodies to this size, 0 for no limit")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: text or json")
+	fs.StringVar(&cfg.ClientKeysFile, "client-keys", cfg.ClientKeysFile, "JSON file of the API keys clients authenticate with")
+	fs.StringVar(&cfg.TokenizerFile, "tokenizer", cfg.TokenizerFile, "tiktoken rank file for token counting, replacing the built-in cl100k_base")
+	fs.StringVar(&cfg.RoutingConfig, "routing-config", cfg.RoutingConfig, "JSON model routing table")
+	fs.StringVar(&cfg.ModelRoutes, "model-routes", cfg.ModelRoutes, "route overrides, e.g. \"*haiku*=gpt-4o-mini\"")
+	return fs
+}
+
+// LoadConfig resolves the configuration from args (without the program name), the
+// environment and the optional config file named by -config or PROXY_CONFIG.
+func LoadConfig(args []string, getenv func(string) string) (Config, error) {
+	// First pass: only look for the config file. The flags start from the defaults so that
+	// -h shows them.
+	scratch := DefaultConfig()
+	fs := newFlagSet("proxy", &scratch)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	configPath := getenv("PROXY_CONFIG")
+	if f := fs.Lookup("config"); f.Value.String() != "" {
+		configPath = f.Value.String()
+	}
+
+	cfg := DefaultConfig()
+	if configPath != "" {
+		b, err := os.ReadFile(configPath)
+		if err != nil {
+			return Config{}, err
+		}
+		if err := json.Unmarshal(b, &cfg); err != nil {
+			return Config{}, fmt.Errorf("parse config %s: %w", configPath, err)
+		}
+	}
+
+	// Environment variables and flags share the flag parsers, so both are validated the same way.
+	apply := newFlagSet("proxy", &cfg)
+	for _, e := range configEnv {
+		if v := getenv(e.env); v != "" {
+			if err := apply.Set(e.flag, v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", e.env, err)
+			}
+		}
+	}
+	// Second pass: explicitly given flags override everything else.
+	apply = newFlagSet("proxy", &cfg)
+	apply.SetOutput(io.Discard)
+	if err := apply.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.APIKey == "" && cfg.CredentialsFile != "" {
+		token, err := loadCredentials(cfg.CredentialsFile)
+		if err != nil {
+			return Config{}, err
+		}
+		cfg.APIKey = token
+	}
+	if cfg.UpstreamURL == "" && cfg.UpstreamType == "openai" {
+		cfg.UpstreamURL = OpenAIProxyURL
+	}
+	cfg.UpstreamURL = strings.TrimRight(cfg.UpstreamURL, "/")
+	cfg.AnthropicURL = strings.TrimRight(cfg.AnthropicURL, "/")
+	return cfg, cfg.Validate()
+}
+
+// Validate checks the configuration for inconsistent settings.
+func (c Config) Validate() error {
+	if c.ListenAddr == "" {
+		return fmt.Errorf("listen address is required")
+	}
+	switch c.UpstreamType {
+	case "openai":
+		if c.UpstreamURL == "" {
+			return fmt.Errorf("upstream URL is required")
+		}
+	case "copilot":
+		if c.APIKey == "" {
+			return fmt.Errorf("copilot upstream requires a GitHub OAuth token as the API key")
+		}
+	case "replay":
+		if c.ReplayFile == "" {
+			return fmt.Errorf("replay upstream requires a replay file")
+		}
+		if c.ReplayMatch != claudecodeproxy.ReplayMatchHash && c.ReplayMatch != claudecodeproxy.ReplayMatchSequence {
+			return fmt.Errorf("unknown replay match mode %q", c.ReplayMatch)
+		}
+	default:
+		return fmt.Errorf("unknown upstream type %q", c.UpstreamType)
+	}
+	for _, u := range []string{c.UpstreamURL, c.CopilotTokenURL, c.AnthropicURL} {
+		if u != "" && !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return fmt.Errorf("URL %q must start with http:// or https://", u)
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("tls cert and key must be set together")
+	}
+	if c.ImageMode != claudecodeproxy.ImageModeReject && c.ImageMode != claudecodeproxy.ImageModeDownscale {
+		return fmt.Errorf("unknown image mode %q", c.ImageMode)
+	}
+	if c.MaxImageBytes < 0 {
+		return fmt.Errorf("max image bytes must not be negative")
+	}
+	if _, err := claudecodeproxy.NewLogger(io.Discard, c.LogLevel, c.LogFormat); err != nil {
+		return err
+	}
+	if c.RecordMaxBodyBytes < 0 {
+		return fmt.Errorf("record max body bytes must not be negative")
+	}
+	if c.ConnectTimeout < 0 || c.ResponseHeaderTimeout < 0 || c.ModelsCacheTTL < 0 {
+		return fmt.Errorf("timeouts must not be negative")
+	}
+	if c.MaxRetries < 0 || c.RetryBaseDelay < 0 || c.RetryMaxDelay < 0 {
+		return fmt.Errorf("retry settings must not be negative")
+	}
+	return nil
+}
+
+// HTTPClient builds the client used for upstream requests.
+func (c Config) HTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.UpstreamInsecureSkipVerify}
+	if c.UpstreamCAFile != "" {
+		pem, err := os.ReadFile(c.UpstreamCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.UpstreamCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	dialer := &net.Dialer{Timeout: time.Duration(c.ConnectTimeout)}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = time.Duration(c.ConnectTimeout)
+	transport.ResponseHeaderTimeout = time.Duration(c.ResponseHeaderTimeout)
+	transport.TLSClientConfig = tlsConfig
+	if c.MaxRetries == 0 {
+		return &http.Client{Transport: transport}, nil
+	}
+	retry := claudecodeproxy.NewRetryTransport(transport, c.MaxRetries)
+	retry.BaseDelay = time.Duration(c.RetryBaseDelay)
+	retry.MaxDelay = time.Duration(c.RetryMaxDelay)
+	return &http.Client{Transport: retry}, nil
+}
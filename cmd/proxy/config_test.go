@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func envMap(m map[string]string) func(string) string {
+	return func(k string) string { return m[k] }
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	cfg, err := LoadConfig(nil, envMap(nil))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if cfg.ListenAddr != ListenAddr || cfg.UpstreamURL != OpenAIProxyURL {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+	if time.Duration(cfg.ConnectTimeout) != 10*time.Second {
+		t.Errorf("Unexpected connect timeout: %v", time.Duration(cfg.ConnectTimeout))
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{
+		"listen_addr": ":9000",
+		"upstream_url": "http://file.example",
+		"api_key": "file-key",
+		"connect_timeout": "3s",
+		"model_routes": "*haiku*=gpt-4o-mini"
+	}`), 0o600)
+
+	env := envMap(map[string]string{
+		"PROXY_CONFIG":    path,
+		"UPSTREAM_URL":    "http://env.example",
+		"COPILOT_API_KEY": "env-key",
+	})
+	cfg, err := LoadConfig([]string{"-api-key", "flag-key", "-response-header-timeout", "1m"}, env)
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if cfg.ListenAddr != ":9000" {
+		t.Errorf("Config file should set listen addr, got %q", cfg.ListenAddr)
+	}
+	if cfg.UpstreamURL != "http://env.example" {
+		t.Errorf("Env should override the config file, got %q", cfg.UpstreamURL)
+	}
+	if cfg.APIKey != "flag-key" {
+		t.Errorf("Flag should override env, got %q", cfg.APIKey)
+	}
+	if time.Duration(cfg.ConnectTimeout) != 3*time.Second || time.Duration(cfg.ResponseHeaderTimeout) != time.Minute {
+		t.Errorf("Timeout mismatch: %v %v", time.Duration(cfg.ConnectTimeout), time.Duration(cfg.ResponseHeaderTimeout))
+	}
+	if cfg.ModelRoutes != "*haiku*=gpt-4o-mini" {
+		t.Errorf("Model routes mismatch: %q", cfg.ModelRoutes)
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{"bad url", []string{"-upstream-url", "ftp://x"}, nil},
+		{"bad env duration", nil, map[string]string{"UPSTREAM_CONNECT_TIMEOUT": "soon"}},
+		{"cert without key", []string{"-tls-cert", "cert.pem"}, nil},
+		{"missing config file", []string{"-config", "/nonexistent/config.json"}, nil},
+		{"bad log level", []string{"-log-level", "verbose"}, nil},
+		{"bad log format", nil, map[string]string{"LOG_FORMAT": "xml"}},
+		{"replay without file", []string{"-upstream-type", "replay"}, nil},
+		{"bad replay match", []string{"-upstream-type", "replay", "-replay", "r.jsonl"}, map[string]string{"REPLAY_MATCH": "random"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadConfig(tt.args, envMap(tt.env)); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	claudecodeproxy "claude-proxy"
+)
+
+// credentials is the on-disk format of the credentials file written by "login".
+type credentials struct {
+	GitHubToken string `json:"github_token"`
+}
+
+// defaultCredentialsFile returns the default location of the credentials file.
+func defaultCredentialsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "claude-proxy", "credentials.json")
+}
+
+// loadCredentials reads the GitHub token from path. A missing file is not an error.
+func loadCredentials(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	var creds credentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return "", fmt.Errorf("parse credentials %s: %w", path, err)
+	}
+	return creds.GitHubToken, nil
+}
+
+// saveCredentials writes the GitHub token to path, readable only by the current user.
+func saveCredentials(path, token string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(credentials{GitHubToken: token}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of an existing file, so enforce it explicitly.
+	if err := os.Chmod(tmp, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// runLogin implements the "login" subcommand: it runs the GitHub OAuth device flow and
+// stores the resulting token in the credentials file.
+func runLogin(ctx context.Context, args []string, getenv func(string) string, out io.Writer) error {
+	flow := claudecodeproxy.NewDeviceFlow()
+	credentialsFile := getenv("CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		credentialsFile = defaultCredentialsFile()
+	}
+
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	fs.StringVar(&credentialsFile, "credentials-file", credentialsFile, "where to store the GitHub token (env CREDENTIALS_FILE)")
+	fs.StringVar(&flow.ClientID, "client-id", flow.ClientID, "GitHub OAuth client ID")
+	fs.StringVar(&flow.Scope, "scope", flow.Scope, "OAuth scope")
+	fs.StringVar(&flow.DeviceCodeURL, "device-code-url", flow.DeviceCodeURL, "GitHub device code endpoint")
+	fs.StringVar(&flow.AccessTokenURL, "access-token-url", flow.AccessTokenURL, "GitHub access token endpoint")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if credentialsFile == "" {
+		return fmt.Errorf("no credentials file location; set -credentials-file")
+	}
+
+	code, err := flow.RequestCode(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Open %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+	fmt.Fprintln(out, "Waiting for authorization...")
+
+	token, err := flow.PollToken(ctx, code)
+	if err != nil {
+		return err
+	}
+	if err := saveCredentials(credentialsFile, token); err != nil {
+		return fmt.Errorf("save credentials: %w", err)
+	}
+	fmt.Fprintf(out, "Logged in. Token saved to %s\n", credentialsFile)
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentials_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "credentials.json")
+	if err := saveCredentials(path, "ghu_saved"); err != nil {
+		t.Fatalf("saveCredentials error: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat error: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Credentials file mode = %o, want 600", perm)
+	}
+	token, err := loadCredentials(path)
+	if err != nil || token != "ghu_saved" {
+		t.Errorf("loadCredentials = %q, %v", token, err)
+	}
+
+	if token, err := loadCredentials(filepath.Join(t.TempDir(), "missing.json")); token != "" || err != nil {
+		t.Errorf("Missing file should yield no token and no error, got %q, %v", token, err)
+	}
+}
+
+func TestLoadConfig_UsesSavedCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := saveCredentials(path, "ghu_saved"); err != nil {
+		t.Fatalf("saveCredentials error: %v", err)
+	}
+
+	cfg, err := LoadConfig([]string{"-credentials-file", path}, envMap(nil))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if cfg.APIKey != "ghu_saved" {
+		t.Errorf("Expected the saved token to be used, got %q", cfg.APIKey)
+	}
+
+	cfg, err = LoadConfig([]string{"-credentials-file", path}, envMap(map[string]string{"COPILOT_API_KEY": "ghu_env"}))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	if cfg.APIKey != "ghu_env" {
+		t.Errorf("COPILOT_API_KEY should win over saved credentials, got %q", cfg.APIKey)
+	}
+}
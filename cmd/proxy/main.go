@@ -1,124 +1,502 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	claudecodeproxy "claude-proxy"
 )
 
+// Defaults used when no configuration overrides them.
 const (
 	OpenAIProxyURL = "https://cope.duti.dev"
 	ListenAddr     = ":8082"
 )
 
-func main() {
-	http.HandleFunc("/v1/messages", handleClaudeMessages)
-	http.HandleFunc("/v1/messages/count_tokens", handleClaudeCountTokens)
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+// maxToolIDs bounds the tool IDs remembered for mapping back to upstream IDs, enough for the
+// tool calls of many concurrent conversations.
+const maxToolIDs = 100000
+
+// server holds the state shared by the HTTP handlers.
+type server struct {
+	cfg    Config
+	logger *slog.Logger
+	client *http.Client
+	// auth adds upstream credentials to each request.
+	auth claudecodeproxy.Authenticator
+	// tokenizer counts tokens for /v1/messages/count_tokens. It uses the configured tiktoken
+	// rank file when set and the built-in cl100k_base otherwise, falling back to an estimate
+	// when the binary was built without it.
+	tokenizer claudecodeproxy.Tokenizer
+	// routing maps Claude model names to upstream models.
+	routing claudecodeproxy.RoutingConfig
+	// openai is the configured OpenAI-compatible upstream, the backend of routes that name none.
+	openai *claudecodeproxy.OpenAIBackend
+	// backends holds the backends routes can select by name.
+	backends map[string]claudecodeproxy.Backend
+	// models lists the routed and upstream models for /v1/models.
+	models *claudecodeproxy.ModelCatalog
+	// recorder records exchanges when RecordFile is set; nil otherwise.
+	recorder *claudecodeproxy.Recorder
+	// metrics are served at /metrics.
+	metrics *claudecodeproxy.Metrics
+	// toolIDs maps the tool_use IDs given to clients back to the upstream's tool call IDs.
+	toolIDs *claudecodeproxy.ToolIDMap
+	// clientKeys are the keys clients must authenticate with; nil accepts every request.
+	clientKeys *claudecodeproxy.ClientKeys
+	// keyAuth holds the upstream credentials of client keys that bring their own, by key.
+	keyAuth map[string]claudecodeproxy.Authenticator
+}
+
+// newServer builds a server from cfg, loading the tokenizer and routing table it references.
+func newServer(cfg Config) (*server, error) {
+	logger, err := claudecodeproxy.NewLogger(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+	client, err := cfg.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		cfg:       cfg,
+		logger:    logger,
+		client:    client,
+		auth:      claudecodeproxy.StaticKey(cfg.APIKey),
+		tokenizer: claudecodeproxy.EstimatingTokenizer{},
+		routing:   claudecodeproxy.DefaultRoutingConfig(),
+		metrics:   claudecodeproxy.NewMetrics(),
+		toolIDs:   claudecodeproxy.NewToolIDMap(maxToolIDs),
+	}
+	if retry, ok := client.Transport.(*claudecodeproxy.RetryTransport); ok {
+		retry.OnRetry = func(*http.Request) { s.metrics.Retries.Inc() }
+	}
+	if cfg.UpstreamType == "copilot" {
+		s.auth = claudecodeproxy.NewCopilotAuth(cfg.APIKey, cfg.CopilotTokenURL, client)
+	}
+	if cfg.TokenizerFile != "" {
+		bpe, err := claudecodeproxy.LoadBPETokenizerFile(cfg.TokenizerFile)
+		if err != nil {
+			return nil, fmt.Errorf("load tokenizer: %w", err)
+		}
+		s.tokenizer = bpe
+	} else if bpe, err := claudecodeproxy.Cl100kTokenizer(); err == nil {
+		s.tokenizer = bpe
+	} else {
+		logger.Warn("No tokenizer available, token counts will be estimated", "error", err)
+	}
+	if cfg.RoutingConfig != "" {
+		routing, err := claudecodeproxy.LoadRoutingConfig(cfg.RoutingConfig)
+		if err != nil {
+			return nil, fmt.Errorf("load routing config: %w", err)
+		}
+		s.routing = routing
+	}
+	if cfg.ModelRoutes != "" {
+		routes, err := claudecodeproxy.ParseRouteOverrides(cfg.ModelRoutes)
+		if err != nil {
+			return nil, fmt.Errorf("parse model routes: %w", err)
+		}
+		s.routing = s.routing.WithOverrides(routes)
+	}
+
+	s.openai = &claudecodeproxy.OpenAIBackend{
+		Client:  client,
+		Auth:    s.auth,
+		URL:     cfg.UpstreamURL,
+		Options: s.convertOptions(),
+	}
+	if cfg.UpstreamURL == "" && cfg.UpstreamType == "openai" {
+		s.openai.URL = OpenAIProxyURL
+	}
+	s.backends = map[string]claudecodeproxy.Backend{claudecodeproxy.BackendOpenAI: s.openai}
+	modelsUpstream := s.openai.Models
+	if cfg.UpstreamType == "replay" {
+		replay, err := claudecodeproxy.LoadReplayBackend(cfg.ReplayFile, cfg.ReplayMatch, cfg.ReplayStrict)
+		if err != nil {
+			return nil, fmt.Errorf("load replay file: %w", err)
+		}
+		replay.Options = s.convertOptions()
+		s.backends[claudecodeproxy.BackendOpenAI] = replay
+		modelsUpstream = nil
+	}
+	if cfg.AnthropicAPIKey != "" {
+		s.backends[claudecodeproxy.BackendAnthropic] = &claudecodeproxy.AnthropicBackend{
+			Client: client,
+			URL:    cfg.AnthropicURL,
+			APIKey: cfg.AnthropicAPIKey,
+		}
+	}
+	for _, route := range s.routing.Routes {
+		if route.Backend != "" && s.backends[route.Backend] == nil {
+			return nil, fmt.Errorf("route %q uses the %s backend, which is not configured", route.Match, route.Backend)
+		}
+	}
+	if cfg.ClientKeysFile != "" {
+		keys, err := claudecodeproxy.LoadClientKeys(cfg.ClientKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client keys: %w", err)
+		}
+		s.clientKeys = keys
+		s.keyAuth = map[string]claudecodeproxy.Authenticator{}
+		for _, key := range keys.Keys {
+			// A key paying for its own upstream must not fall back to the shared Anthropic key.
+			if key.UpstreamAPIKey != "" && key.AnthropicAPIKey == "" && s.backends[claudecodeproxy.BackendAnthropic] != nil {
+				return nil, fmt.Errorf("client key of %s: upstream_api_key needs an anthropic_api_key too, as the anthropic backend is configured", key.User)
+			}
+			switch {
+			case key.UpstreamAPIKey == "":
+			case cfg.UpstreamType == "copilot":
+				s.keyAuth[key.Key] = claudecodeproxy.NewCopilotAuth(key.UpstreamAPIKey, cfg.CopilotTokenURL, client)
+			default:
+				s.keyAuth[key.Key] = claudecodeproxy.StaticKey(key.UpstreamAPIKey)
+			}
+		}
+	} else {
+		logger.Warn("No client keys configured, the proxy accepts requests from anyone who can reach it")
+	}
+	if cfg.RecordFile != "" {
+		recorder, err := claudecodeproxy.OpenRecorder(cfg.RecordFile, cfg.RecordMaxBodyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("open record file: %w", err)
+		}
+		s.recorder = recorder
+	}
+	s.models = &claudecodeproxy.ModelCatalog{
+		Upstream: modelsUpstream,
+		Routing:  s.routing,
+		TTL:      time.Duration(cfg.ModelsCacheTTL),
+	}
+	return s, nil
+}
+
+// convertOptions returns the conversion settings derived from the configuration.
+func (s *server) convertOptions() claudecodeproxy.ConvertOptions {
+	return claudecodeproxy.ConvertOptions{
+		Images: claudecodeproxy.ImageOptions{
+			MaxBytes: s.cfg.MaxImageBytes,
+			Mode:     s.cfg.ImageMode,
+		},
+		ToolIDs: s.toolIDs,
+	}
+}
+
+// backend returns the backend serving route.
+func (s *server) backend(route claudecodeproxy.ModelRoute) claudecodeproxy.Backend {
+	if b, ok := s.backends[route.Backend]; ok {
+		return b
+	}
+	return s.backends[claudecodeproxy.BackendOpenAI]
+}
+
+// handler returns the HTTP handler serving all proxy endpoints.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/messages", s.handleClaudeMessages)
+	mux.HandleFunc("/v1/messages/count_tokens", s.handleClaudeCountTokens)
+	mux.HandleFunc("/v1/chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("/v1/models", s.handleModels)
+	mux.HandleFunc("/v1/models/", s.handleModels)
+	mux.Handle("/metrics", s.metrics)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`{"message": "Claude Proxy for OpenAI"}`))
 	})
-	log.Printf("Claude proxy listening on %s", ListenAddr)
-	log.Fatal(http.ListenAndServe(ListenAddr, nil))
+	return s.withRequestLogging(s.withClientAuth(mux))
 }
 
-func handleClaudeMessages(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// withClientAuth rejects requests to the /v1 endpoints that do not carry a configured client
+// key. Authenticated requests get the key, its upstream credentials if it has any, and a logger
+// naming its user.
+func (s *server) withClientAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.clientKeys == nil || !strings.HasPrefix(r.URL.Path, "/v1/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, err := s.clientKeys.Authenticate(r)
+		if err != nil {
+			claudecodeproxy.LoggerFrom(r.Context()).Info("Rejected unauthenticated request", "error", err)
+			claudecodeproxy.WriteError(w, err)
+			return
+		}
+		ctx := claudecodeproxy.WithClientKey(r.Context(), key)
+		ctx = claudecodeproxy.WithLogger(ctx, claudecodeproxy.LoggerFrom(ctx).With("user", key.User))
+		if auth, ok := s.keyAuth[key.Key]; ok {
+			ctx = claudecodeproxy.WithUpstreamAuth(ctx, auth)
+		}
+		if key.AnthropicAPIKey != "" {
+			ctx = claudecodeproxy.WithAnthropicAPIKey(ctx, key.AnthropicAPIKey)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-	var claudeReq claudecodeproxy.ClaudeMessagesRequest
-	if err := json.NewDecoder(r.Body).Decode(&claudeReq); err != nil {
-		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
-		return
+// withRequestLogging gives every request an ID, returned in the request-id header, and a
+// logger carrying it for the handlers, and logs each request once it is done. Requests that
+// reached a backend are counted in the metrics.
+func (s *server) withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := claudecodeproxy.NewRequestID()
+		logger := s.logger.With("request_id", id)
+		w.Header().Set("request-id", id)
+		sw := &statusWriter{ResponseWriter: w}
+		labels := &requestLabels{}
+		start := time.Now()
+		ctx := context.WithValue(claudecodeproxy.WithLogger(r.Context(), logger), requestLabelsKey{}, labels)
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		logger.Info("Request done", "method", r.Method, "path", r.URL.Path, "status", sw.Status(), "duration", time.Since(start).Round(time.Millisecond))
+		if labels.claudeModel != "" {
+			s.metrics.Requests.Inc(labels.claudeModel, labels.upstreamModel, strconv.Itoa(sw.Status()))
+			if r.Context().Err() != nil {
+				s.metrics.Cancellations.Inc()
+			}
+		}
+	})
+}
+
+// requestLabels holds the models a request's metrics are labelled with, set once it is routed.
+type requestLabels struct {
+	claudeModel, upstreamModel string
+}
+
+type requestLabelsKey struct{}
+
+// modelLabel returns the metrics label of a model name from a client: the name if the routing
+// table lists it, the pattern of the route matching it, or "other", so that clients cannot add
+// time series at will.
+func (s *server) modelLabel(model string) string {
+	if slices.Contains(s.routing.ModelNames(), model) {
+		return model
 	}
+	if s.routing.Matches(model) {
+		return s.routing.Route(model).Match
+	}
+	return "other"
+}
 
-	// Convert Claude request to OpenAI request
-	oaiReq, err := claudecodeproxy.ConvertClaudeToOAI(claudeReq)
+// sendMessages sends req to the backend of its route, recording the exchange in rec. The
+// returned events are counted in the metrics as they are read.
+func (s *server) sendMessages(r *http.Request, rec *claudecodeproxy.Recording, req claudecodeproxy.BackendRequest, stream bool) (io.ReadCloser, error) {
+	upstreamModel := req.Route.Upstream
+	if upstreamModel == "" {
+		upstreamModel = req.Claude.Model
+	}
+	upstreamModel = s.modelLabel(upstreamModel)
+	if labels, ok := r.Context().Value(requestLabelsKey{}).(*requestLabels); ok {
+		labels.claudeModel, labels.upstreamModel = s.modelLabel(req.Claude.Model), upstreamModel
+	}
+	if key, ok := claudecodeproxy.ClientKeyFrom(r.Context()); ok && !key.AllowsModel(req.Claude.Model) {
+		return nil, claudecodeproxy.NewAPIError(http.StatusForbidden, "the API key of %s may not use model %s", key.User, req.Claude.Model)
+	}
+	start := time.Now()
+	events, err := s.backend(req.Route).Messages(claudecodeproxy.WithRecording(r.Context(), rec), req)
 	if err != nil {
-		http.Error(w, "Conversion error: "+err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+	s.metrics.UpstreamLatency.Observe(time.Since(start).Seconds(), upstreamModel)
+	if stream {
+		s.metrics.Responses.Inc("streamed")
+	} else {
+		s.metrics.Responses.Inc("buffered")
+	}
+	return s.metrics.ObserveEvents(rec.TeeEvents(events), start, upstreamModel), nil
+}
+
+// statusWriter records the status code written to a ResponseWriter.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush keeps streaming working through the wrapper.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Status returns the status code written, 200 if only a body was written.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// fatal logs msg as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "login" {
+		err := runLogin(context.Background(), os.Args[2:], os.Getenv, os.Stdout)
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			fatal("Login failed", "error", err)
+		}
 		return
 	}
 
-	// Marshal OAI request
-	oaiBody, err := json.Marshal(oaiReq)
-	if err != nil {
-		http.Error(w, "Marshal error: "+err.Error(), http.StatusInternalServerError)
+	cfg, err := LoadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	s, err := newServer(cfg)
+	if err != nil {
+		fatal("Failed to start", "error", err)
+	}
+	slog.SetDefault(s.logger)
 
-	// Always stream from OpenAI, even if user requested non-stream.
-	// We'll buffer and convert to non-stream if needed.
-	oaiReq.Stream = true
+	if copilot, ok := s.auth.(*claudecodeproxy.CopilotAuth); ok {
+		if _, err := copilot.Token(context.Background()); err != nil {
+			fatal("Failed to obtain a Copilot token", "error", err)
+		}
+		copilot.Start(context.Background())
+	}
 
-	// Marshal OAI request (again, in case Stream changed)
-	oaiBody, err = json.Marshal(oaiReq)
-	if err != nil {
-		http.Error(w, "Marshal error: "+err.Error(), http.StatusInternalServerError)
+	upstream, _ := s.openai.BaseURL(context.Background())
+	if cfg.UpstreamType == "replay" {
+		upstream = cfg.ReplayFile
+	}
+	slog.Info("Claude proxy listening", "addr", cfg.ListenAddr, "upstream", upstream)
+	if cfg.TLSCertFile != "" {
+		err = http.ListenAndServeTLS(cfg.ListenAddr, cfg.TLSCertFile, cfg.TLSKeyFile, s.handler())
+	} else {
+		err = http.ListenAndServe(cfg.ListenAddr, s.handler())
+	}
+	fatal("Server stopped", "error", err)
+}
+
+func (s *server) handleClaudeMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		claudecodeproxy.WriteError(w, claudecodeproxy.NewAPIError(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
 		return
 	}
 
-	// Forward to OpenAI proxy
-	req, err := http.NewRequest("POST", OpenAIProxyURL+"/chat/completions", bytes.NewReader(oaiBody))
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Request error: "+err.Error(), http.StatusInternalServerError)
+		claudecodeproxy.WriteError(w, claudecodeproxy.NewAPIError(http.StatusBadRequest, "read error: %v", err))
 		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	// Forward API key if present
-	if apiKey := os.Getenv("COPILOT_API_KEY"); apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+apiKey)
+	var claudeReq claudecodeproxy.ClaudeMessagesRequest
+	if err := json.Unmarshal(body, &claudeReq); err != nil {
+		claudecodeproxy.WriteError(w, claudecodeproxy.NewAPIError(http.StatusBadRequest, "invalid JSON: %v", err))
+		return
 	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	logger := claudecodeproxy.LoggerFrom(r.Context())
+	rec := s.recorder.Start(r, body)
+	defer s.finishRecording(logger, rec)
+	route := s.routing.RouteRequest(claudeReq)
+	stream := claudeReq.Stream != nil && *claudeReq.Stream
+	logger.Debug("Routing request", "model", claudeReq.Model, "upstream", route.Upstream, "backend", route.Backend, "stream", stream)
+	events, err := s.sendMessages(r, rec, claudecodeproxy.BackendRequest{
+		Claude:        claudeReq,
+		Body:          body,
+		Header:        r.Header,
+		Route:         route,
+		ResponseModel: s.routing.ResponseModel(claudeReq),
+	}, stream)
 	if err != nil {
-		http.Error(w, "Proxy error: "+err.Error(), http.StatusBadGateway)
+		rec.SetError(err)
+		claudecodeproxy.WriteError(w, err)
 		return
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		log.Printf("WARNING: Upstream returned non-200 status: %d %s", resp.StatusCode, body)
-	}
+	defer events.Close()
 
-	if claudeReq.Stream != nil && *claudeReq.Stream {
+	if stream {
 		// User requested streaming, so proxy as stream
 		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
 		w.WriteHeader(http.StatusOK)
-		claudecodeproxy.ConvertOAIStreamToClaudeStream(resp.Body, w, claudeReq.Model)
+		// Failures from here on are reported to the client as error events; cancellations are
+		// logged by the converter.
+		err := claudecodeproxy.CopySSE(w, events)
+		rec.SetError(err)
+		var apiErr *claudecodeproxy.APIError
+		if errors.As(err, &apiErr) {
+			logger.Warn("Stream ended with error", "error", err)
+		}
 		return
 	} else {
-		// User requested non-stream, so buffer the stream and convert to non-stream response
-		var buf bytes.Buffer
-		err := claudecodeproxy.ConvertOAIStreamToClaudeStream(resp.Body, &buf, claudeReq.Model)
-		if err != nil {
-			http.Error(w, "Stream conversion error: "+err.Error(), http.StatusInternalServerError)
+		// User requested non-stream, so parse the events to reconstruct a ClaudeMessagesResponse
+		claudeResp, err := claudecodeproxy.ParseClaudeStreamToResponse(events)
+		rec.SetError(err)
+		if r.Context().Err() != nil {
+			// Nobody is left to read the response
 			return
 		}
-		// Now parse the buffered events to reconstruct a ClaudeMessagesResponse
-		claudeResp, err := claudecodeproxy.ParseClaudeStreamToResponse(&buf)
 		if err != nil {
-			http.Error(w, "Claude stream parse error: "+err.Error(), http.StatusInternalServerError)
+			claudecodeproxy.WriteError(w, err)
 			return
 		}
-		// Log the output of claudeResp for verification
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(claudeResp)
 		return
 	}
 }
 
-func handleClaudeCountTokens(w http.ResponseWriter, r *http.Request) {
-	respObj := map[string]int{"input_tokens": 0}
+// finishRecording writes rec to the record file.
+func (s *server) finishRecording(logger *slog.Logger, rec *claudecodeproxy.Recording) {
+	if err := rec.Finish(); err != nil {
+		logger.Warn("Failed to record exchange", "error", err)
+	}
+}
+
+func (s *server) handleClaudeCountTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		claudecodeproxy.WriteError(w, claudecodeproxy.NewAPIError(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
+		return
+	}
+
+	var countReq claudecodeproxy.ClaudeTokenCountRequest
+	if err := json.NewDecoder(r.Body).Decode(&countReq); err != nil {
+		claudecodeproxy.WriteError(w, claudecodeproxy.NewAPIError(http.StatusBadRequest, "invalid JSON: %v", err))
+		return
+	}
+
+	inputTokens, err := claudecodeproxy.CountClaudeTokens(countReq, s.tokenizer)
+	if err != nil {
+		claudecodeproxy.WriteError(w, claudecodeproxy.NewAPIError(http.StatusBadRequest, "conversion error: %v", err))
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(respObj)
+	json.NewEncoder(w).Encode(claudecodeproxy.ClaudeTokenCountResponse{InputTokens: inputTokens})
 }
 
 // Helper: Convert OAIResponse to ClaudeMessagesResponse
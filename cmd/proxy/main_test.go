@@ -0,0 +1,651 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	claudecodeproxy "claude-proxy"
+)
+
+// newTestServer starts a proxy pointed at upstream and returns its base URL.
+func newTestServer(t *testing.T, upstream http.Handler, configure func(*Config)) string {
+	t.Helper()
+	up := httptest.NewServer(upstream)
+	t.Cleanup(up.Close)
+
+	cfg := DefaultConfig()
+	cfg.UpstreamURL = up.URL
+	cfg.APIKey = "test-key"
+	if configure != nil {
+		configure(&cfg)
+	}
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatalf("newServer error: %v", err)
+	}
+	proxy := httptest.NewServer(s.handler())
+	t.Cleanup(proxy.Close)
+	return proxy.URL
+}
+
+// streamingUpstream replies with the given OpenAI SSE body and records the last request.
+func streamingUpstream(body string, gotReq *claudecodeproxy.OAIRequest, gotAuth *string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if gotAuth != nil {
+			*gotAuth = r.Header.Get("Authorization")
+		}
+		if gotReq != nil {
+			json.NewDecoder(r.Body).Decode(gotReq)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, body)
+	})
+}
+
+const textOnlyUpstream = `data: {"id":"cmpl-abc","object":"chat.completion.chunk","created":123,"model":"gpt-4.1","choices":[{"delta":{"content":"Hello"},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+
+func TestHandleClaudeMessages_NonStream(t *testing.T) {
+	var gotReq claudecodeproxy.OAIRequest
+	var gotAuth string
+	proxyURL := newTestServer(t, streamingUpstream(textOnlyUpstream, &gotReq, &gotAuth), func(cfg *Config) {
+		cfg.ModelRoutes = "*sonnet*=stub-model"
+	})
+
+	resp, err := http.Post(proxyURL+"/v1/messages", "application/json", strings.NewReader(
+		`{"model":"claude-3-sonnet-20240229","max_tokens":64,"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %d", resp.StatusCode)
+	}
+	var claudeResp claudecodeproxy.ClaudeMessagesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&claudeResp); err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if len(claudeResp.Content) != 1 {
+		t.Fatalf("Expected one content block, got %+v", claudeResp.Content)
+	}
+	if block, _ := claudeResp.Content[0].(map[string]any); block["text"] != "Hello" {
+		t.Errorf("Content mismatch: got %+v", claudeResp.Content[0])
+	}
+	if !strings.HasPrefix(claudeResp.ID, "msg_01") {
+		t.Errorf("Message ID mismatch: got %q, want a generated msg_ ID", claudeResp.ID)
+	}
+	if gotReq.Model != "stub-model" {
+		t.Errorf("Upstream model mismatch: got %q", gotReq.Model)
+	}
+	if gotAuth != "Bearer test-key" {
+		t.Errorf("Upstream auth mismatch: got %q", gotAuth)
+	}
+}
+
+func TestHandleClaudeCountTokens(t *testing.T) {
+	proxyURL := newTestServer(t, http.NotFoundHandler(), nil)
+	resp, err := http.Post(proxyURL+"/v1/messages/count_tokens", "application/json", strings.NewReader(
+		`{"model":"claude-3-sonnet-20240229","system":"Be brief.","messages":[{"role":"user","content":"Hello there"}]}`))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	defer resp.Body.Close()
+	var count claudecodeproxy.ClaudeTokenCountResponse
+	if err := json.NewDecoder(resp.Body).Decode(&count); err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if count.InputTokens <= 0 {
+		t.Errorf("Expected a positive token count, got %d", count.InputTokens)
+	}
+}
+
+func TestHandleClaudeMessages_CopilotUpstream(t *testing.T) {
+	var gotAuth, gotEditor string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotEditor = r.Header.Get("Editor-Version")
+		io.WriteString(w, textOnlyUpstream)
+	}))
+	defer upstream.Close()
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token ghu_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"token":      "copilot-session",
+			"expires_at": 4102444800,
+			"endpoints":  map[string]any{"api": upstream.URL},
+		})
+	}))
+	defer tokenServer.Close()
+
+	cfg, err := LoadConfig([]string{"-upstream-type", "copilot", "-copilot-token-url", tokenServer.URL}, envMap(map[string]string{
+		"COPILOT_API_KEY": "ghu_test",
+	}))
+	if err != nil {
+		t.Fatalf("LoadConfig error: %v", err)
+	}
+	s, err := newServer(cfg)
+	if err != nil {
+		t.Fatalf("newServer error: %v", err)
+	}
+	proxy := httptest.NewServer(s.handler())
+	defer proxy.Close()
+
+	resp, err := http.Post(proxy.URL+"/v1/messages", "application/json", strings.NewReader(
+		`{"model":"claude-3-sonnet-20240229","max_tokens":64,"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %d", resp.StatusCode)
+	}
+	if gotAuth != "Bearer copilot-session" {
+		t.Errorf("Expected the Copilot session token upstream, got %q", gotAuth)
+	}
+	if gotEditor == "" {
+		t.Errorf("Expected Copilot editor headers upstream")
+	}
+}
+
+func TestHandleClaudeMessages_UpstreamError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		stream     bool
+		wantType   string
+		wantMsg    string
+		retryAfter string
+	}{
+		{"RateLimited", http.StatusTooManyRequests, `{"error":{"message":"Rate limit reached"}}`, false, "rate_limit_error", "Rate limit reached", "7"},
+		{"RateLimitedStream", http.StatusTooManyRequests, `{"error":{"message":"Rate limit reached"}}`, true, "rate_limit_error", "Rate limit reached", "7"},
+		{"ServerError", http.StatusInternalServerError, "internal failure", false, "api_error", "internal failure", ""},
+		{"BadRequest", http.StatusBadRequest, `{"error":{"message":"context too long"}}`, true, "invalid_request_error", "context too long", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxyURL := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}), func(cfg *Config) {
+				cfg.MaxRetries = 0
+			})
+
+			resp, err := http.Post(proxyURL+"/v1/messages", "application/json", strings.NewReader(
+				`{"model":"claude-3-sonnet-20240229","max_tokens":64,"stream":`+strconv.FormatBool(tt.stream)+`,"messages":[{"role":"user","content":"Hi"}]}`))
+			if err != nil {
+				t.Fatalf("POST error: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("Status mismatch: got %d, want %d", resp.StatusCode, tt.status)
+			}
+			if got := resp.Header.Get("Retry-After"); got != tt.retryAfter {
+				t.Errorf("Retry-After mismatch: got %q, want %q", got, tt.retryAfter)
+			}
+			var body claudecodeproxy.ClaudeErrorResponse
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("Decode error: %v", err)
+			}
+			want := claudecodeproxy.ClaudeErrorResponse{Type: "error", Error: claudecodeproxy.ClaudeError{Type: tt.wantType, Message: tt.wantMsg}}
+			if body != want {
+				t.Errorf("Body mismatch: got %+v, want %+v", body, want)
+			}
+		})
+	}
+}
+
+func TestHandleClaudeMessages_RetriesUpstream(t *testing.T) {
+	var calls int
+	proxyURL := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, textOnlyUpstream)
+	}), func(cfg *Config) {
+		cfg.RetryBaseDelay = Duration(time.Millisecond)
+	})
+
+	resp, err := http.Post(proxyURL+"/v1/messages", "application/json", strings.NewReader(
+		`{"model":"claude-3-sonnet-20240229","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status mismatch: got %d, want 200: %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(string(body), `"text":"Hello"`) {
+		t.Errorf("Expected the streamed text, got: %s", body)
+	}
+	if calls != 3 {
+		t.Errorf("Upstream call count mismatch: got %d, want 3", calls)
+	}
+}
+
+func TestHandleClaudeMessages_ClientDisconnect(t *testing.T) {
+	upstreamDone := make(chan struct{})
+	proxyURL := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `data: {"id":"cmpl-abc","object":"chat.completion.chunk","created":123,"model":"gpt-4.1","choices":[{"delta":{"content":"Hel"}}]}`+"\n\n")
+		w.(http.Flusher).Flush()
+		// Keep generating until the proxy gives up on us.
+		<-r.Context().Done()
+		close(upstreamDone)
+	}), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, proxyURL+"/v1/messages", strings.NewReader(
+		`{"model":"claude-3-sonnet-20240229","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	defer resp.Body.Close()
+	// Wait for the first text delta, then hang up.
+	events := claudecodeproxy.NewSSEReader(resp.Body)
+	for {
+		ev, err := events.ReadEvent()
+		if err != nil {
+			t.Fatalf("ReadEvent error: %v", err)
+		}
+		if ev.Event == "content_block_delta" {
+			break
+		}
+	}
+	cancel()
+
+	select {
+	case <-upstreamDone:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Upstream request was not cancelled after the client disconnected")
+	}
+}
+
+func TestHandleClaudeMessages_NonStreamBlockOrder(t *testing.T) {
+	upstream := `data: {"id":"cmpl-abc","object":"chat.completion.chunk","model":"gpt-4.1","choices":[{"delta":{"content":"Reading."}}]}
+
+data: {"id":"cmpl-abc","object":"chat.completion.chunk","model":"gpt-4.1","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"Read","arguments":"{}"}}]}}]}
+
+data: {"id":"cmpl-abc","object":"chat.completion.chunk","model":"gpt-4.1","choices":[{"delta":{"content":"Then more."}}]}
+
+data: {"id":"cmpl-abc","object":"chat.completion.chunk","model":"gpt-4.1","choices":[{"delta":{},"finish_reason":"tool_calls"}]}
+
+data: [DONE]
+
+`
+	proxyURL := newTestServer(t, streamingUpstream(upstream, nil, nil), nil)
+	resp, err := http.Post(proxyURL+"/v1/messages", "application/json", strings.NewReader(
+		`{"model":"claude-3-sonnet-20240229","max_tokens":64,"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	defer resp.Body.Close()
+	var claudeResp struct {
+		Content []struct {
+			Type string `json:"type"`
+		} `json:"content"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&claudeResp); err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	var got []string
+	for _, block := range claudeResp.Content {
+		got = append(got, block.Type)
+	}
+	if want := []string{"text", "tool_use", "text"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Block order mismatch: got %v, want %v", got, want)
+	}
+}
+
+func TestHandleClaudeMessages_AnthropicRoute(t *testing.T) {
+	const stream = `event: message_start
+data: {"type":"message_start","message":{"id":"msg_ant","model":"claude-opus-4-20250514","usage":{"input_tokens":3}}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+	var gotKey, gotModel string
+	anthropic := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("x-api-key")
+		var body struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		gotModel = body.Model
+		io.WriteString(w, stream)
+	}))
+	defer anthropic.Close()
+	routes := filepath.Join(t.TempDir(), "routes.json")
+	os.WriteFile(routes, []byte(`{"default_model":"gpt-4.1","routes":[{"match":"*opus*","backend":"anthropic"}]}`), 0o600)
+
+	var gotReq claudecodeproxy.OAIRequest
+	proxyURL := newTestServer(t, streamingUpstream(textOnlyUpstream, &gotReq, nil), func(cfg *Config) {
+		cfg.RoutingConfig = routes
+		cfg.AnthropicURL = anthropic.URL
+		cfg.AnthropicAPIKey = "sk-ant-test"
+	})
+
+	resp, err := http.Post(proxyURL+"/v1/messages", "application/json", strings.NewReader(
+		`{"model":"claude-opus-4-20250514","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != stream {
+		t.Errorf("Expected the Anthropic stream unchanged, got: %s", body)
+	}
+	if gotKey != "sk-ant-test" || gotModel != "claude-opus-4-20250514" {
+		t.Errorf("Anthropic request mismatch: key %q, model %q", gotKey, gotModel)
+	}
+
+	// Other models still go to the OpenAI upstream.
+	resp, err = http.Post(proxyURL+"/v1/messages", "application/json", strings.NewReader(
+		`{"model":"claude-3-sonnet-20240229","max_tokens":64,"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	resp.Body.Close()
+	if gotReq.Model != "gpt-4.1" {
+		t.Errorf("Upstream model mismatch: got %q", gotReq.Model)
+	}
+}
+
+func TestNewServer_UnconfiguredBackend(t *testing.T) {
+	routes := filepath.Join(t.TempDir(), "routes.json")
+	os.WriteFile(routes, []byte(`{"default_model":"gpt-4.1","routes":[{"match":"*opus*","backend":"anthropic"}]}`), 0o600)
+	cfg := DefaultConfig()
+	cfg.RoutingConfig = routes
+	if _, err := newServer(cfg); err == nil || !strings.Contains(err.Error(), "anthropic") {
+		t.Errorf("Expected an error for the missing Anthropic key, got %v", err)
+	}
+}
+
+func TestHandleClaudeMessages_Record(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "record.jsonl")
+	proxyURL := newTestServer(t, streamingUpstream(textOnlyUpstream, nil, nil), func(cfg *Config) {
+		cfg.RecordFile = recordFile
+	})
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodPost, proxyURL+"/v1/messages", strings.NewReader(
+			`{"model":"claude-3-sonnet-20240229","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`))
+		req.Header.Set("x-api-key", "client-secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST error: %v", err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	b, err := os.ReadFile(recordFile)
+	if err != nil {
+		t.Fatalf("ReadFile error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected two records, got %d", len(lines))
+	}
+	var rec claudecodeproxy.Recording
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if rec.Header.Get("X-Api-Key") != "REDACTED" || len(rec.UpstreamChunks) != 2 || len(rec.ClaudeEvents) == 0 || rec.OAIRequest == nil {
+		t.Errorf("Unexpected record: %s", lines[0])
+	}
+}
+
+func TestHandleClaudeMessages_Replay(t *testing.T) {
+	recordFile := filepath.Join(t.TempDir(), "record.jsonl")
+	const request = `{"model":"claude-3-sonnet-20240229","max_tokens":64,"messages":[{"role":"user","content":"Hi"}]}`
+	post := func(proxyURL, body string) (int, string) {
+		resp, err := http.Post(proxyURL+"/v1/messages", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST error: %v", err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+	recordURL := newTestServer(t, streamingUpstream(textOnlyUpstream, nil, nil), func(cfg *Config) {
+		cfg.RecordFile = recordFile
+	})
+	_, recorded := post(recordURL, request)
+
+	upstreamCalled := false
+	replayURL := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalled = true
+	}), func(cfg *Config) {
+		cfg.UpstreamType = "replay"
+		cfg.ReplayFile = recordFile
+		cfg.ReplayStrict = true
+	})
+	// Message IDs are generated for every response, so they are left out of the comparison.
+	withoutID := func(body string) string {
+		return regexp.MustCompile(`"id":"msg_\w+"`).ReplaceAllString(body, `"id":""`)
+	}
+	if status, replayed := post(replayURL, request); status != http.StatusOK || withoutID(replayed) != withoutID(recorded) {
+		t.Errorf("Replay mismatch: got %d %s, want %s", status, replayed, recorded)
+	}
+	if status, _ := post(replayURL, strings.Replace(request, "Hi", "Bye", 1)); status != http.StatusBadGateway {
+		t.Errorf("Expected strict replay to fail an unmatched request, got %d", status)
+	}
+	if upstreamCalled {
+		t.Error("Replay must not call the upstream")
+	}
+}
+
+func TestHandler_RequestID(t *testing.T) {
+	proxyURL := newTestServer(t, streamingUpstream(textOnlyUpstream, nil, nil), nil)
+	var ids []string
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(proxyURL+"/v1/messages", "application/json", strings.NewReader(
+			`{"model":"claude-3-sonnet-20240229","max_tokens":64,"messages":[{"role":"user","content":"Hi"}]}`))
+		if err != nil {
+			t.Fatalf("POST error: %v", err)
+		}
+		resp.Body.Close()
+		ids = append(ids, resp.Header.Get("request-id"))
+	}
+	if !strings.HasPrefix(ids[0], "req_") || ids[0] == ids[1] {
+		t.Errorf("Expected unique request IDs, got %q", ids)
+	}
+}
+
+func TestHandler_Metrics(t *testing.T) {
+	upstream := `data: {"id":"cmpl-abc","object":"chat.completion.chunk","model":"gpt-4.1","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"Read","arguments":"{}"}}]}}]}
+
+data: {"id":"cmpl-abc","object":"chat.completion.chunk","model":"gpt-4.1","choices":[{"delta":{},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":12,"completion_tokens":5}}
+
+data: [DONE]
+
+`
+	proxyURL := newTestServer(t, streamingUpstream(upstream, nil, nil), func(cfg *Config) {
+		cfg.ModelRoutes = "claude-3-sonnet-20240229=stub-model,*haiku*=stub-model"
+	})
+	// Models the routing table does not name are labelled with the matching pattern or "other".
+	for _, req := range []struct{ model, stream string }{
+		{"claude-3-sonnet-20240229", "true"},
+		{"claude-3-sonnet-20240229", "false"},
+		{"claude-3-haiku-made-up", "false"},
+		{"made-up-model", "false"},
+	} {
+		resp, err := http.Post(proxyURL+"/v1/messages", "application/json", strings.NewReader(
+			`{"model":"`+req.model+`","max_tokens":64,"stream":`+req.stream+`,"messages":[{"role":"user","content":"Hi"}]}`))
+		if err != nil {
+			t.Fatalf("POST error: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	want := []string{
+		`claude_proxy_requests_total{claude_model="claude-3-sonnet-20240229",upstream_model="stub-model",status="200"} 2`,
+		`claude_proxy_requests_total{claude_model="*haiku*",upstream_model="stub-model",status="200"} 1`,
+		`claude_proxy_requests_total{claude_model="other",upstream_model="gpt-4.1",status="200"} 1`,
+		`claude_proxy_responses_total{mode="buffered"} 3`,
+		`claude_proxy_responses_total{mode="streamed"} 1`,
+		`claude_proxy_upstream_latency_seconds_count{upstream_model="stub-model"} 3`,
+		`claude_proxy_time_to_first_token_seconds_count{upstream_model="stub-model"} 3`,
+		`claude_proxy_input_tokens_total{upstream_model="stub-model"} 36`,
+		`claude_proxy_output_tokens_total{upstream_model="stub-model"} 15`,
+		`claude_proxy_tool_calls_total{upstream_model="stub-model"} 3`,
+		`claude_proxy_upstream_retries_total 0`,
+		`claude_proxy_cancellations_total 0`,
+	}
+	// Requests are counted once their handler returns, which may be after the client is done.
+	var body string
+	for i := 0; i < 50; i++ {
+		resp, err := http.Get(proxyURL + "/metrics")
+		if err != nil {
+			t.Fatalf("GET error: %v", err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if body = string(b); strings.Contains(body, want[0]) && strings.Contains(body, want[1]) && strings.Contains(body, want[2]) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, line := range want {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestHandler_ClientKeys(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(keysFile, []byte(`{"keys":[
+		{"key":"sk-alice","user":"alice","upstream_api_key":"alice-upstream"},
+		{"key":"sk-bob","user":"bob","models":["*haiku*"]}
+	]}`), 0o600)
+	var gotAuth string
+	proxyURL := newTestServer(t, streamingUpstream(textOnlyUpstream, nil, &gotAuth), func(cfg *Config) {
+		cfg.ClientKeysFile = keysFile
+	})
+	post := func(header http.Header, model string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, proxyURL+"/v1/messages", strings.NewReader(
+			`{"model":"`+model+`","max_tokens":64,"messages":[{"role":"user","content":"Hi"}]}`))
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST error: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		model      string
+		wantStatus int
+		wantType   string
+		wantAuth   string
+	}{
+		{"NoKey", http.Header{}, "claude-3-sonnet-20240229", http.StatusUnauthorized, "authentication_error", ""},
+		{"WrongKey", http.Header{"X-Api-Key": {"sk-mallory"}}, "claude-3-sonnet-20240229", http.StatusUnauthorized, "authentication_error", ""},
+		{"OwnUpstreamKey", http.Header{"Authorization": {"Bearer sk-alice"}}, "claude-3-sonnet-20240229", http.StatusOK, "", "Bearer alice-upstream"},
+		{"SharedUpstreamKey", http.Header{"X-Api-Key": {"sk-bob"}}, "claude-3-haiku-20240307", http.StatusOK, "", "Bearer test-key"},
+		{"ModelNotAllowed", http.Header{"X-Api-Key": {"sk-bob"}}, "claude-3-sonnet-20240229", http.StatusForbidden, "permission_error", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAuth = ""
+			resp := post(tt.header, tt.model)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("Status mismatch: got %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantType != "" {
+				var body claudecodeproxy.ClaudeErrorResponse
+				if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Type != "error" || body.Error.Type != tt.wantType {
+					t.Errorf("Error body mismatch: got %+v (%v), want type %s", body, err, tt.wantType)
+				}
+			}
+			if gotAuth != tt.wantAuth {
+				t.Errorf("Upstream auth mismatch: got %q, want %q", gotAuth, tt.wantAuth)
+			}
+		})
+	}
+
+	// Metrics stay reachable without a key.
+	resp, err := http.Get(proxyURL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Metrics status mismatch: got %d, want 200", resp.StatusCode)
+	}
+}
+
+func TestHandler_ClientKeysAnthropic(t *testing.T) {
+	var gotKey string
+	anthropic := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = r.Header.Get("x-api-key")
+		io.WriteString(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer anthropic.Close()
+	dir := t.TempDir()
+	routes := filepath.Join(dir, "routes.json")
+	os.WriteFile(routes, []byte(`{"default_model":"gpt-4.1","routes":[{"match":"*opus*","backend":"anthropic"}]}`), 0o600)
+	keysFile := filepath.Join(dir, "keys.json")
+	os.WriteFile(keysFile, []byte(`{"keys":[
+		{"key":"sk-alice","user":"alice","upstream_api_key":"alice-upstream","anthropic_api_key":"sk-ant-alice"},
+		{"key":"sk-bob","user":"bob"}
+	]}`), 0o600)
+	configure := func(cfg *Config) {
+		cfg.RoutingConfig = routes
+		cfg.AnthropicURL = anthropic.URL
+		cfg.AnthropicAPIKey = "sk-ant-test"
+		cfg.ClientKeysFile = keysFile
+	}
+	proxyURL := newTestServer(t, streamingUpstream(textOnlyUpstream, nil, nil), configure)
+
+	for clientKey, want := range map[string]string{"sk-alice": "sk-ant-alice", "sk-bob": "sk-ant-test"} {
+		req, _ := http.NewRequest(http.MethodPost, proxyURL+"/v1/messages", strings.NewReader(
+			`{"model":"claude-opus-4-20250514","max_tokens":64,"stream":true,"messages":[{"role":"user","content":"Hi"}]}`))
+		req.Header.Set("X-Api-Key", clientKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST error: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if gotKey != want {
+			t.Errorf("Anthropic key of %s mismatch: got %q, want %q", clientKey, gotKey, want)
+		}
+	}
+
+	// A key with its own upstream credential may not fall back to the shared Anthropic key.
+	os.WriteFile(keysFile, []byte(`{"keys":[{"key":"sk-alice","user":"alice","upstream_api_key":"alice-upstream"}]}`), 0o600)
+	cfg := DefaultConfig()
+	configure(&cfg)
+	if _, err := newServer(cfg); err == nil || !strings.Contains(err.Error(), "anthropic_api_key") {
+		t.Errorf("Expected an error for the missing anthropic_api_key, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	claudecodeproxy "claude-proxy"
+)
+
+// handleModels serves /v1/models and /v1/models/{id}. Claude clients, which send an
+// anthropic-version header, get the Anthropic format; everyone else the OpenAI one.
+func (s *server) handleModels(w http.ResponseWriter, r *http.Request) {
+	anthropic := r.Header.Get("anthropic-version") != ""
+	writeError := claudecodeproxy.WriteOAIError
+	if anthropic {
+		writeError = claudecodeproxy.WriteError
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, claudecodeproxy.NewAPIError(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if id, ok := strings.CutPrefix(r.URL.Path, "/v1/models/"); ok {
+		model, found := s.models.Model(r.Context(), id)
+		if key, ok := claudecodeproxy.ClientKeyFrom(r.Context()); ok && !key.AllowsModel(id) {
+			found = false
+		}
+		if !found {
+			writeError(w, claudecodeproxy.NewAPIError(http.StatusNotFound, "model: %s", id))
+			return
+		}
+		if anthropic {
+			json.NewEncoder(w).Encode(model)
+		} else {
+			json.NewEncoder(w).Encode(oaiModel(model))
+		}
+		return
+	}
+
+	models := s.models.Models(r.Context())
+	if key, ok := claudecodeproxy.ClientKeyFrom(r.Context()); ok {
+		// Keys limited to some models only see those.
+		allowed := models[:0:0]
+		for _, m := range models {
+			if key.AllowsModel(m.ID) {
+				allowed = append(allowed, m)
+			}
+		}
+		models = allowed
+	}
+	if !anthropic {
+		list := claudecodeproxy.OAIModelList{Object: "list", Data: []claudecodeproxy.OAIModel{}}
+		for _, m := range models {
+			list.Data = append(list.Data, oaiModel(m))
+		}
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+	q := r.URL.Query()
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	json.NewEncoder(w).Encode(claudecodeproxy.PageModels(models, limit, q.Get("after_id"), q.Get("before_id")))
+}
+
+func oaiModel(m claudecodeproxy.ClaudeModel) claudecodeproxy.OAIModel {
+	model := claudecodeproxy.OAIModel{
+		ID:      m.ID,
+		Object:  "model",
+		OwnedBy: "claude-proxy",
+	}
+	if !m.CreatedAt.IsZero() {
+		model.Created = m.CreatedAt.Unix()
+	}
+	return model
+}
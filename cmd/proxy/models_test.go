@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	claudecodeproxy "claude-proxy"
+)
+
+// modelsUpstream serves an OpenAI /models list and counts the requests.
+func modelsUpstream(calls *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/models" {
+			http.NotFound(w, r)
+			return
+		}
+		*calls++
+		io.WriteString(w, `{"object":"list","data":[{"id":"gpt-4.1","object":"model","created":1700000000,"name":"GPT-4.1"},{"id":"o3","object":"model"}]}`)
+	})
+}
+
+func getModels(t *testing.T, url string, anthropic bool, v any) int {
+	t.Helper()
+	return getModelsWithKey(t, url, anthropic, "", v)
+}
+
+func getModelsWithKey(t *testing.T, url string, anthropic bool, apiKey string, v any) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if anthropic {
+		req.Header.Set("anthropic-version", "2023-06-01")
+	}
+	if apiKey != "" {
+		req.Header.Set("x-api-key", apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET error: %v", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestHandleModels_Anthropic(t *testing.T) {
+	var calls int
+	proxyURL := newTestServer(t, modelsUpstream(&calls), func(cfg *Config) {
+		cfg.ModelRoutes = "claude-sonnet-4-20250514=gpt-4.1"
+	})
+
+	var list claudecodeproxy.ClaudeModelList
+	getModels(t, proxyURL+"/v1/models", true, &list)
+	var ids []string
+	for _, m := range list.Data {
+		ids = append(ids, m.ID)
+	}
+	if got := strings.Join(ids, ","); got != "claude-sonnet-4-20250514,gpt-4.1,gpt-4o-mini,o4-mini,o3" {
+		t.Errorf("Model list mismatch: got %s", got)
+	}
+	if list.FirstID == nil || *list.FirstID != "claude-sonnet-4-20250514" || list.LastID == nil || *list.LastID != "o3" || list.HasMore {
+		t.Errorf("List metadata mismatch: %+v", list)
+	}
+
+	var model claudecodeproxy.ClaudeModel
+	if status := getModels(t, proxyURL+"/v1/models/gpt-4.1", true, &model); status != http.StatusOK || model.DisplayName != "GPT-4.1" {
+		t.Errorf("Model mismatch: status %d, %+v", status, model)
+	}
+	var errResp claudecodeproxy.ClaudeErrorResponse
+	if status := getModels(t, proxyURL+"/v1/models/davinci", true, &errResp); status != http.StatusNotFound || errResp.Error.Type != "not_found_error" {
+		t.Errorf("Expected a not_found_error, got status %d, %+v", status, errResp)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the upstream list to be cached, got %d calls", calls)
+	}
+}
+
+func TestHandleModels_OpenAI(t *testing.T) {
+	var calls int
+	proxyURL := newTestServer(t, modelsUpstream(&calls), func(cfg *Config) {
+		cfg.ModelRoutes = "*sonnet*=stub-model"
+	})
+	var list claudecodeproxy.OAIModelList
+	getModels(t, proxyURL+"/v1/models", false, &list)
+	var ids []string
+	for _, m := range list.Data {
+		if m.Object != "model" {
+			t.Errorf("Object mismatch: got %q", m.Object)
+		}
+		ids = append(ids, m.ID)
+	}
+	if list.Object != "list" || !strings.Contains(strings.Join(ids, ","), "stub-model") || !strings.Contains(strings.Join(ids, ","), "o3") {
+		t.Errorf("Model list mismatch: got %+v", list)
+	}
+}
+
+func TestHandleModels_ClientKeyModels(t *testing.T) {
+	var calls int
+	keysFile := filepath.Join(t.TempDir(), "keys.json")
+	os.WriteFile(keysFile, []byte(`{"keys":[{"key":"sk-bob","user":"bob","models":["gpt-4*"]}]}`), 0o600)
+	proxyURL := newTestServer(t, modelsUpstream(&calls), func(cfg *Config) {
+		cfg.ClientKeysFile = keysFile
+	})
+
+	var list claudecodeproxy.ClaudeModelList
+	getModelsWithKey(t, proxyURL+"/v1/models", true, "sk-bob", &list)
+	var ids []string
+	for _, m := range list.Data {
+		ids = append(ids, m.ID)
+	}
+	if got := strings.Join(ids, ","); got != "gpt-4.1,gpt-4o-mini" {
+		t.Errorf("Model list mismatch: got %s", got)
+	}
+	var errResp claudecodeproxy.ClaudeErrorResponse
+	if status := getModelsWithKey(t, proxyURL+"/v1/models/o3", true, "sk-bob", &errResp); status != http.StatusNotFound {
+		t.Errorf("Expected models outside the key's list to be hidden, got status %d", status)
+	}
+	if status := getModelsWithKey(t, proxyURL+"/v1/models", true, "", &errResp); status != http.StatusUnauthorized || errResp.Error.Type != "authentication_error" {
+		t.Errorf("Expected an authentication_error, got status %d, %+v", status, errResp)
+	}
+}
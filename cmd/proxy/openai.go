@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	claudecodeproxy "claude-proxy"
+)
+
+// handleChatCompletions serves OpenAI clients. Requests are converted to Claude requests and
+// take the same path as /v1/messages; the resulting Claude events are converted back.
+func (s *server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		claudecodeproxy.WriteOAIError(w, claudecodeproxy.NewAPIError(http.StatusMethodNotAllowed, "method %s not allowed", r.Method))
+		return
+	}
+
+	var oaiReq claudecodeproxy.OAIRequest
+	if err := json.NewDecoder(r.Body).Decode(&oaiReq); err != nil {
+		claudecodeproxy.WriteOAIError(w, claudecodeproxy.NewAPIError(http.StatusBadRequest, "invalid JSON: %v", err))
+		return
+	}
+	claudeReq, err := claudecodeproxy.ConvertOAIToClaude(oaiReq)
+	if err != nil {
+		claudecodeproxy.WriteOAIError(w, claudecodeproxy.NewAPIError(http.StatusBadRequest, "conversion error: %v", err))
+		return
+	}
+
+	// OpenAI clients may name an upstream model directly; those are passed through.
+	route := s.routing.RouteRequest(claudeReq)
+	if !s.routing.Matches(claudeReq.Model) {
+		route.Upstream = claudeReq.Model
+	}
+	logger := claudecodeproxy.LoggerFrom(r.Context())
+	rec := s.recorder.Start(r, nil)
+	rec.SetClaudeRequest(claudeReq)
+	defer s.finishRecording(logger, rec)
+	logger.Debug("Routing request", "model", claudeReq.Model, "upstream", route.Upstream, "backend", route.Backend, "stream", oaiReq.Stream)
+	claudeEvents, err := s.sendMessages(r, rec, claudecodeproxy.BackendRequest{
+		Claude:        claudeReq,
+		Header:        r.Header,
+		Route:         route,
+		ResponseModel: claudeReq.Model,
+	}, oaiReq.Stream)
+	if err != nil {
+		rec.SetError(err)
+		claudecodeproxy.WriteOAIError(w, err)
+		return
+	}
+	defer claudeEvents.Close()
+
+	if oaiReq.Stream {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		includeUsage := oaiReq.StreamOptions != nil && oaiReq.StreamOptions.IncludeUsage
+		err := claudecodeproxy.ConvertClaudeStreamToOAIStream(claudeEvents, w, includeUsage)
+		rec.SetError(err)
+		var apiErr *claudecodeproxy.APIError
+		if errors.As(err, &apiErr) {
+			logger.Warn("Stream ended with error", "error", err)
+		}
+		return
+	}
+
+	claudeResp, err := claudecodeproxy.ParseClaudeStreamToResponse(claudeEvents)
+	rec.SetError(err)
+	if r.Context().Err() != nil {
+		return
+	}
+	if err != nil {
+		claudecodeproxy.WriteOAIError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(claudecodeproxy.ConvertClaudeResponseToOAI(claudeResp))
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	claudecodeproxy "claude-proxy"
+)
+
+func TestHandleChatCompletions_NonStream(t *testing.T) {
+	var gotReq claudecodeproxy.OAIRequest
+	proxyURL := newTestServer(t, streamingUpstream(textOnlyUpstream, &gotReq, nil), func(cfg *Config) {
+		cfg.ModelRoutes = "*sonnet*=stub-model"
+	})
+
+	resp, err := http.Post(proxyURL+"/v1/chat/completions", "application/json", strings.NewReader(
+		`{"model":"claude-3-sonnet-20240229","messages":[{"role":"system","content":"Be brief."},{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Unexpected status %d", resp.StatusCode)
+	}
+	var oaiResp struct {
+		Object  string `json:"object"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&oaiResp); err != nil {
+		t.Fatalf("Decode error: %v", err)
+	}
+	if oaiResp.Object != "chat.completion" || len(oaiResp.Choices) != 1 {
+		t.Fatalf("Unexpected response %+v", oaiResp)
+	}
+	if c := oaiResp.Choices[0]; c.Message.Content != "Hello" || c.FinishReason != "stop" {
+		t.Errorf("Choice mismatch: got %+v", c)
+	}
+	if gotReq.Model != "stub-model" {
+		t.Errorf("Upstream model mismatch: got %q", gotReq.Model)
+	}
+	if len(gotReq.Messages) != 2 || gotReq.Messages[0].Role != "system" {
+		t.Errorf("Upstream messages mismatch: got %+v", gotReq.Messages)
+	}
+	// Without max_tokens from the client the upstream picks the limit.
+	if gotReq.MaxTokens != 0 {
+		t.Errorf("Upstream max_tokens mismatch: got %d, want unset", gotReq.MaxTokens)
+	}
+}
+
+func TestHandleChatCompletions_Stream(t *testing.T) {
+	var gotReq claudecodeproxy.OAIRequest
+	proxyURL := newTestServer(t, streamingUpstream(textOnlyUpstream, &gotReq, nil), nil)
+
+	resp, err := http.Post(proxyURL+"/v1/chat/completions", "application/json", strings.NewReader(
+		`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type mismatch: got %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"content":"Hello"`) || !strings.HasSuffix(string(body), "data: [DONE]\n\n") {
+		t.Errorf("Unexpected stream: %s", body)
+	}
+	// Models outside the routing table are passed through to the upstream.
+	if gotReq.Model != "gpt-4o" {
+		t.Errorf("Upstream model mismatch: got %q", gotReq.Model)
+	}
+}
+
+func TestHandleChatCompletions_UnsupportedParameter(t *testing.T) {
+	called := false
+	proxyURL := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}), nil)
+
+	resp, err := http.Post(proxyURL+"/v1/chat/completions", "application/json", strings.NewReader(
+		`{"model":"gpt-4o","n":3,"messages":[{"role":"user","content":"Hi"}]}`))
+	if err != nil {
+		t.Fatalf("POST error: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body.Error.Message, "n must be 1") {
+		t.Errorf("Expected a 400 naming n, got %d %+v", resp.StatusCode, body)
+	}
+	if called {
+		t.Error("Expected the request not to reach the upstream")
+	}
+}
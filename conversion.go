@@ -2,10 +2,12 @@ package claudecodeproxy
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"net/http"
 	"strings"
 )
 
@@ -22,7 +24,9 @@ func ConvertToolChoiceClaudeToOAI(toolChoice any) any {
 	case "auto":
 		return "auto"
 	case "any":
-		return "any"
+		return "required"
+	case "none":
+		return "none"
 	case "tool":
 		if name, ok := tc["name"].(string); ok {
 			return map[string]any{
@@ -47,21 +51,22 @@ func ParseClaudeStreamToResponse(r io.Reader) (ClaudeMessagesResponse, error) {
 	var id string
 	var model string
 
-	var toolUseBlocks []*ClaudeContentBlockToolUse
 	var currentToolUseBlock *ClaudeContentBlockToolUse
 	var currentToolInputBuilder strings.Builder
 	var currentTextBlock *ClaudeContentBlockText
+	var currentThinkingBlock *ClaudeContentBlockThinking
 
-	dec := json.NewDecoder(&eventStreamStripper{r: r})
+	reader := NewSSEReader(r)
 
 	for {
-		var event struct {
-			Event string          `json:"event"`
-			Data  json.RawMessage `json:"data"`
-		}
-		if err := dec.Decode(&event); err != nil {
+		event, err := reader.ReadEvent()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return resp, err
+		}
+		data := []byte(event.Data)
 		switch event.Event {
 		case "message_start":
 			var msg struct {
@@ -71,7 +76,7 @@ func ParseClaudeStreamToResponse(r io.Reader) (ClaudeMessagesResponse, error) {
 					Usage ClaudeUsage `json:"usage"`
 				} `json:"message"`
 			}
-			if err := json.Unmarshal(event.Data, &msg); err == nil {
+			if err := json.Unmarshal(data, &msg); err == nil {
 				id = msg.Message.ID
 				model = msg.Message.Model
 				usage = msg.Message.Usage
@@ -84,8 +89,15 @@ func ParseClaudeStreamToResponse(r io.Reader) (ClaudeMessagesResponse, error) {
 					Name string `json:"name,omitempty"`
 				} `json:"content_block"`
 			}
-			if err := json.Unmarshal(event.Data, &cb); err == nil {
+			if err := json.Unmarshal(data, &cb); err == nil {
+				currentThinkingBlock = nil
 				switch cb.ContentBlock.Type {
+				case "thinking":
+					currentThinkingBlock = &ClaudeContentBlockThinking{Type: "thinking"}
+					contentBlocks = append(contentBlocks, currentThinkingBlock)
+					currentTextBlock = nil
+					currentToolUseBlock = nil
+					currentToolInputBuilder.Reset()
 				case "text":
 					textBlock := &ClaudeContentBlockText{Type: "text", Text: ""}
 					contentBlocks = append(contentBlocks, textBlock)
@@ -100,6 +112,8 @@ func ParseClaudeStreamToResponse(r io.Reader) (ClaudeMessagesResponse, error) {
 							Name:  cb.ContentBlock.Name,
 							Input: map[string]any{},
 						}
+						// Blocks keep their stream position; the input is filled in at content_block_stop.
+						contentBlocks = append(contentBlocks, currentToolUseBlock)
 						currentToolInputBuilder.Reset()
 					} else {
 						currentToolUseBlock = nil
@@ -114,9 +128,11 @@ func ParseClaudeStreamToResponse(r io.Reader) (ClaudeMessagesResponse, error) {
 					Type        string `json:"type"`
 					Text        string `json:"text,omitempty"`
 					PartialJSON string `json:"partial_json,omitempty"`
+					Thinking    string `json:"thinking,omitempty"`
+					Signature   string `json:"signature,omitempty"`
 				} `json:"delta"`
 			}
-			if err := json.Unmarshal(event.Data, &d); err == nil {
+			if err := json.Unmarshal(data, &d); err == nil {
 				switch d.Delta.Type {
 				case "text_delta":
 					if currentTextBlock != nil {
@@ -130,6 +146,14 @@ func ParseClaudeStreamToResponse(r io.Reader) (ClaudeMessagesResponse, error) {
 					if currentToolUseBlock != nil {
 						currentToolInputBuilder.WriteString(d.Delta.PartialJSON)
 					}
+				case "thinking_delta":
+					if currentThinkingBlock != nil {
+						currentThinkingBlock.Thinking += d.Delta.Thinking
+					}
+				case "signature_delta":
+					if currentThinkingBlock != nil {
+						currentThinkingBlock.Signature += d.Delta.Signature
+					}
 				}
 			}
 		case "content_block_stop":
@@ -144,30 +168,44 @@ func ParseClaudeStreamToResponse(r io.Reader) (ClaudeMessagesResponse, error) {
 					input = map[string]any{}
 				}
 				currentToolUseBlock.Input = input
-				toolUseBlocks = append(toolUseBlocks, currentToolUseBlock)
 				currentToolUseBlock = nil
 				currentToolInputBuilder.Reset()
 			}
 			currentTextBlock = nil
+			currentThinkingBlock = nil
 		case "message_delta":
 			var d struct {
 				Delta struct {
 					StopReason   *string `json:"stop_reason"`
 					StopSequence *string `json:"stop_sequence"`
 				} `json:"delta"`
-				Usage struct {
-					OutputTokens int `json:"output_tokens"`
-				} `json:"usage"`
+				Usage ClaudeUsage `json:"usage"`
 			}
-			if err := json.Unmarshal(event.Data, &d); err == nil {
+			if err := json.Unmarshal(data, &d); err == nil {
 				stopReason = d.Delta.StopReason
 				stopSequence = d.Delta.StopSequence
+				// message_delta carries the final counts; zero means not reported.
+				if d.Usage.InputTokens > 0 {
+					usage.InputTokens = d.Usage.InputTokens
+				}
 				if d.Usage.OutputTokens > 0 {
 					usage.OutputTokens = d.Usage.OutputTokens
 				}
+				if d.Usage.CacheReadInputTokens > 0 {
+					usage.CacheReadInputTokens = d.Usage.CacheReadInputTokens
+				}
+				if d.Usage.CacheCreationInputTokens > 0 {
+					usage.CacheCreationInputTokens = d.Usage.CacheCreationInputTokens
+				}
 			}
 		case "message_stop":
 			// done
+		case "error":
+			var e ClaudeErrorResponse
+			if err := json.Unmarshal(data, &e); err != nil {
+				return resp, NewAPIError(http.StatusBadGateway, "malformed error event: %s", event.Data)
+			}
+			return resp, &APIError{Status: statusForErrorType(e.Error.Type), Type: e.Error.Type, Message: e.Error.Message}
 		}
 	}
 
@@ -178,11 +216,13 @@ func ParseClaudeStreamToResponse(r io.Reader) (ClaudeMessagesResponse, error) {
 				continue
 			}
 		}
+		if thinkingBlock, ok := block.(*ClaudeContentBlockThinking); ok {
+			if thinkingBlock.Thinking == "" {
+				continue
+			}
+		}
 		filteredContentBlocks = append(filteredContentBlocks, block)
 	}
-	for _, tub := range toolUseBlocks {
-		filteredContentBlocks = append(filteredContentBlocks, tub)
-	}
 
 	resp = ClaudeMessagesResponse{
 		ID:           id,
@@ -197,239 +237,123 @@ func ParseClaudeStreamToResponse(r io.Reader) (ClaudeMessagesResponse, error) {
 	return resp, nil
 }
 
-// eventStreamStripper is an io.Reader that strips "data: " prefixes and blank lines from an event stream.
-type eventStreamStripper struct {
-	r   io.Reader
-	buf []byte
-}
-
-func (e *eventStreamStripper) Read(p []byte) (int, error) {
-	if len(e.buf) == 0 {
-		// Read a line from the underlying reader
-		var lineBuf [4096]byte
-		n, err := e.r.Read(lineBuf[:])
-		if n == 0 && err != nil {
-			return 0, err
-		}
-		lines := strings.Split(string(lineBuf[:n]), "\n")
-		var out []byte
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
+// ConvertSystemClaudeToOAI flattens a Claude system prompt (a string or a list of
+// text blocks) into a single string. Blocks are joined with newlines and any
+// block metadata such as cache_control is dropped.
+func ConvertSystemClaudeToOAI(system any) string {
+	var parts []string
+	switch s := system.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case []ClaudeSystemContent:
+		for _, block := range s {
+			if block.Text != "" {
+				parts = append(parts, block.Text)
 			}
-			if strings.HasPrefix(line, "data: ") {
-				line = line[6:]
+		}
+	case []any:
+		for _, block := range s {
+			switch b := block.(type) {
+			case ClaudeSystemContent:
+				if b.Text != "" {
+					parts = append(parts, b.Text)
+				}
+			case map[string]any:
+				if t, _ := b["type"].(string); t != "" && t != "text" {
+					continue
+				}
+				if text, ok := b["text"].(string); ok && text != "" {
+					parts = append(parts, text)
+				}
+			case string:
+				if b != "" {
+					parts = append(parts, b)
+				}
 			}
-			out = append(out, line...)
 		}
-		e.buf = out
 	}
-	n := copy(p, e.buf)
-	e.buf = e.buf[n:]
-	return n, nil
+	return strings.Join(parts, "\n")
+}
+
+// ConvertOptions tunes ConvertClaudeToOAIWithOptions and ConvertOAIStreamToClaudeStreamWithOptions.
+type ConvertOptions struct {
+	Images ImageOptions
+	// Logger receives conversion warnings, such as dropped content. Nil uses slog.Default().
+	Logger *slog.Logger
+	// IDs generates message and tool_use IDs. Nil uses RandomIDs.
+	IDs IDGenerator
+	// ToolIDs maps the generated tool_use IDs back to the upstream's tool call IDs. Nil sends
+	// the Claude IDs upstream.
+	ToolIDs *ToolIDMap
+}
+
+func (o ConvertOptions) logger() *slog.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return slog.Default()
+}
+
+func (o ConvertOptions) ids() IDGenerator {
+	if o.IDs != nil {
+		return o.IDs
+	}
+	return RandomIDs{}
 }
 
+// ConvertClaudeToOAI converts a ClaudeMessagesRequest to an OAIRequest with default options.
 func ConvertClaudeToOAI(req ClaudeMessagesRequest) (OAIRequest, error) {
+	return ConvertClaudeToOAIWithOptions(req, ConvertOptions{})
+}
+
+// ConvertClaudeToOAIWithOptions converts a ClaudeMessagesRequest to an OAIRequest.
+func ConvertClaudeToOAIWithOptions(req ClaudeMessagesRequest, opts ConvertOptions) (OAIRequest, error) {
 	var oaiReq OAIRequest
-	oaiReq.Model = "gpt-4.1"
-	if strings.Contains(req.Model, "haiku") {
-		oaiReq.Model = "gpt-4o-mini"
-	}
+	oaiReq.Model = DefaultRoutingConfig().RouteRequest(req).Upstream
 	oaiReq.MaxTokens = req.MaxTokens
 	oaiReq.Temperature = req.Temperature
 	oaiReq.TopP = req.TopP
 	oaiReq.TopK = req.TopK
 	oaiReq.Stream = true
+	// Ask for a trailing usage chunk so token counts can be reported.
+	oaiReq.StreamOptions = &OAIStreamOptions{IncludeUsage: true}
+
+	if req.Thinking.IsEnabled() {
+		oaiReq.ReasoningEffort = ReasoningEffortForBudget(req.Thinking.BudgetTokens)
+		// Reasoning models only accept max_completion_tokens.
+		oaiReq.MaxTokens, oaiReq.MaxCompletionTokens = 0, req.MaxTokens
+		// Like Claude with thinking, reasoning models only accept the default sampling settings.
+		oaiReq.Temperature = nil
+		oaiReq.TopP = nil
+		oaiReq.TopK = nil
+	}
 
 	// Convert stop_sequences to stop
 	if req.StopSequences != nil {
 		oaiReq.Stop = req.StopSequences
 	}
 
-	// Convert Claude messages to OAI messages
-	for _, cm := range req.Messages {
-		var oaiContents []OAIMessageContent
-
-		// Handle tool_result blocks in user messages as plain text, as in server.py
-		if cm.Role == "user" {
-			switch content := cm.Content.(type) {
-			case []ClaudeContentBlockText:
-				for _, block := range content {
-					oaiContents = append(oaiContents, OAIMessageContent{
-						Type: "text",
-						Text: block.Text,
-					})
-				}
-			case []any:
-				// If any block is type tool_result, flatten to text
-				hasToolResult := false
-				var textContent strings.Builder
-				for _, block := range content {
-					switch b := block.(type) {
-					case ClaudeContentBlockToolResult:
-						hasToolResult = true
-						toolID := b.ToolUseID
-						textContent.WriteString(fmt.Sprintf("Tool Result for %s:\n", toolID))
-						switch rc := b.Content.(type) {
-						case []ClaudeContentBlockText:
-							for _, item := range rc {
-								textContent.WriteString(item.Text + "\n")
-							}
-						case string:
-							textContent.WriteString(rc + "\n")
-						default:
-							bb, _ := json.Marshal(rc)
-							textContent.WriteString(string(bb) + "\n")
-						}
-					case ClaudeContentBlockText:
-						textContent.WriteString(b.Text + "\n")
-					default:
-						// fallback for legacy map[string]any
-						if blockMap, ok := b.(map[string]any); ok {
-							if blockMap["type"] == "tool_result" {
-								hasToolResult = true
-								toolID := ""
-								if v, ok := blockMap["tool_use_id"].(string); ok {
-									toolID = v
-								}
-								textContent.WriteString(fmt.Sprintf("Tool Result for %s:\n", toolID))
-								resultContent := blockMap["content"]
-								switch rc := resultContent.(type) {
-								case []any:
-									for _, item := range rc {
-										if itemMap, ok := item.(map[string]any); ok {
-											if itemMap["type"] == "text" {
-												if s, ok := itemMap["text"].(string); ok {
-													textContent.WriteString(s + "\n")
-												}
-											} else if s, ok := itemMap["text"].(string); ok {
-												textContent.WriteString(s + "\n")
-											} else {
-												b, _ := json.Marshal(itemMap)
-												textContent.WriteString(string(b) + "\n")
-											}
-										} else if s, ok := item.(string); ok {
-											textContent.WriteString(s + "\n")
-										} else {
-											b, _ := json.Marshal(item)
-											textContent.WriteString(string(b) + "\n")
-										}
-									}
-								case map[string]any:
-									if rc["type"] == "text" {
-										if s, ok := rc["text"].(string); ok {
-											textContent.WriteString(s + "\n")
-										}
-									} else {
-										b, _ := json.Marshal(rc)
-										textContent.WriteString(string(b) + "\n")
-									}
-								case string:
-									textContent.WriteString(rc + "\n")
-								default:
-									b, _ := json.Marshal(rc)
-									textContent.WriteString(string(b) + "\n")
-								}
-							} else if blockMap["type"] == "text" {
-								if s, ok := blockMap["text"].(string); ok {
-									textContent.WriteString(s + "\n")
-								}
-							}
-						}
-					}
-				}
-				if hasToolResult {
-					oaiContents = append(oaiContents, OAIMessageContent{
-						Type: "text",
-						Text: strings.TrimSpace(textContent.String()),
-					})
-				} else {
-					// Fallback to normal handling
-					for _, block := range content {
-						if b, ok := block.(ClaudeContentBlockText); ok {
-							oaiContents = append(oaiContents, OAIMessageContent{
-								Type: "text",
-								Text: b.Text,
-							})
-						} else if blockMap, ok := block.(map[string]any); ok {
-							if blockMap["type"] == "text" {
-								if s, ok := blockMap["text"].(string); ok {
-									oaiContents = append(oaiContents, OAIMessageContent{
-										Type: "text",
-										Text: s,
-									})
-								}
-							}
-						}
-					}
-				}
-			case string:
-				oaiContents = append(oaiContents, OAIMessageContent{
-					Type: "text",
-					Text: content,
-				})
-			default:
-				// Fallback: marshal to string
-				b, _ := json.Marshal(content)
-				oaiContents = append(oaiContents, OAIMessageContent{
-					Type: "text",
-					Text: string(b),
-				})
-			}
-		} else {
-			// For assistant and other roles, preserve text blocks, ignore tool_use/tool_result
-			switch content := cm.Content.(type) {
-			case string:
-				oaiContents = append(oaiContents, OAIMessageContent{
-					Type: "text",
-					Text: content,
-				})
-			case []ClaudeContentBlockText:
-				for _, block := range content {
-					oaiContents = append(oaiContents, OAIMessageContent{
-						Type: "text",
-						Text: block.Text,
-					})
-				}
-			case []any:
-				for _, block := range content {
-					if b, ok := block.(ClaudeContentBlockText); ok {
-						oaiContents = append(oaiContents, OAIMessageContent{
-							Type: "text",
-							Text: b.Text,
-						})
-					} else if blockMap, ok := block.(map[string]any); ok {
-						if blockMap["type"] == "text" {
-							if s, ok := blockMap["text"].(string); ok {
-								oaiContents = append(oaiContents, OAIMessageContent{
-									Type: "text",
-									Text: s,
-								})
-							}
-						}
-					}
-				}
-			default:
-				b, _ := json.Marshal(content)
-				oaiContents = append(oaiContents, OAIMessageContent{
-					Type: "text",
-					Text: string(b),
-				})
-			}
-		}
-
-		// If content is empty or null, set to "..." to avoid nulls in OAI
-		if len(oaiContents) == 0 {
-			continue
-		}
-
+	// Convert the system prompt to a leading system message
+	if system := ConvertSystemClaudeToOAI(req.System); system != "" {
 		oaiReq.Messages = append(oaiReq.Messages, OAIMessage{
-			Role:    cm.Role,
-			Content: oaiContents,
+			Role: "system",
+			Content: []OAIMessageContent{
+				{Type: "text", Text: system},
+			},
 		})
 	}
 
+	// Convert Claude messages to OAI messages
+	for i, cm := range req.Messages {
+		msgs, err := convertMessageClaudeToOAI(cm, opts)
+		if err != nil {
+			return oaiReq, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		oaiReq.Messages = append(oaiReq.Messages, msgs...)
+	}
+
 	// Convert tools to OAI function tools
 	if req.Tools != nil {
 		var oaiTools []OAIFunctionTool
@@ -454,11 +378,227 @@ func ConvertClaudeToOAI(req ClaudeMessagesRequest) (OAIRequest, error) {
 	return oaiReq, nil
 }
 
-// ConvertOAIToClaude converts an OAIRequest to a ClaudeMessagesRequest.
+// ReasoningEffortForBudget maps a Claude thinking budget to an OpenAI reasoning_effort. The
+// thresholds put Claude Code's "think" (4k), "think hard" (10k) and "ultrathink" (32k) budgets
+// on low, medium and high.
+func ReasoningEffortForBudget(budgetTokens int) string {
+	switch {
+	case budgetTokens < 8192:
+		return "low"
+	case budgetTokens < 24576:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// convertMessageClaudeToOAI converts a single Claude message into one or more OAI messages.
+// Assistant tool_use blocks become tool_calls on the assistant message, and every user
+// tool_result block becomes its own "tool" message. Tool messages are emitted before the
+// remaining user content because OpenAI requires them to directly follow the assistant
+// message that issued the calls. Tool messages can only carry text, so images returned by
+// tools are moved into the following user message.
+func convertMessageClaudeToOAI(cm ClaudeMessage, opts ConvertOptions) ([]OAIMessage, error) {
+	var oaiContents []OAIMessageContent
+	var toolCalls []OAIMessageToolCall
+	var toolMessages []OAIMessage
+	var err error
+
+	addText := func(text string) {
+		oaiContents = append(oaiContents, OAIMessageContent{
+			Type: "text",
+			Text: text,
+		})
+	}
+	addToolCall := func(id, name string, input any) {
+		if input == nil {
+			input = map[string]any{}
+		}
+		args, _ := json.Marshal(input)
+		toolCalls = append(toolCalls, OAIMessageToolCall{
+			ID:   opts.ToolIDs.UpstreamID(id),
+			Type: "function",
+			Function: OAIToolCallFunction{
+				Name:      name,
+				Arguments: string(args),
+			},
+		})
+	}
+	addImage := func(source map[string]any) {
+		if err != nil {
+			return
+		}
+		var part OAIMessageContent
+		if part, err = convertImageClaudeToOAI(source, opts.Images); err == nil {
+			oaiContents = append(oaiContents, part)
+		}
+	}
+	addToolResult := func(toolUseID string, content any) {
+		images := 0
+		if items, ok := content.([]any); ok {
+			for _, item := range items {
+				switch it := item.(type) {
+				case ClaudeContentBlockImage:
+					addImage(it.Source)
+					images++
+				case map[string]any:
+					if it["type"] == "image" {
+						source, _ := it["source"].(map[string]any)
+						addImage(source)
+						images++
+					}
+				}
+			}
+		}
+		text := toolResultToText(content)
+		if text == "" && images > 0 {
+			text = "[image attached in the next message]"
+		}
+		toolMessages = append(toolMessages, OAIMessage{
+			Role:       "tool",
+			ToolCallID: opts.ToolIDs.UpstreamID(toolUseID),
+			Content: []OAIMessageContent{
+				{Type: "text", Text: text},
+			},
+		})
+	}
+
+	switch content := cm.Content.(type) {
+	case string:
+		addText(content)
+	case []ClaudeContentBlockText:
+		for _, block := range content {
+			addText(block.Text)
+		}
+	case []any:
+		for _, block := range content {
+			switch b := block.(type) {
+			case ClaudeContentBlockText:
+				addText(b.Text)
+			case ClaudeContentBlockToolUse:
+				addToolCall(b.ID, b.Name, b.Input)
+			case ClaudeContentBlockToolResult:
+				addToolResult(b.ToolUseID, b.Content)
+			case ClaudeContentBlockImage:
+				addImage(b.Source)
+			case map[string]any:
+				switch b["type"] {
+				case "text":
+					if s, ok := b["text"].(string); ok {
+						addText(s)
+					}
+				case "image":
+					source, _ := b["source"].(map[string]any)
+					addImage(source)
+				case "tool_use":
+					id, _ := b["id"].(string)
+					name, _ := b["name"].(string)
+					addToolCall(id, name, b["input"])
+				case "tool_result":
+					id, _ := b["tool_use_id"].(string)
+					addToolResult(id, b["content"])
+				case "thinking", "redacted_thinking":
+					// Upstream reasoning cannot be sent back, so earlier thinking is dropped.
+					opts.logger().Debug("Dropping thinking block", "role", cm.Role)
+				default:
+					opts.logger().Warn("Dropping unsupported content block", "role", cm.Role, "type", b["type"])
+				}
+			default:
+				opts.logger().Warn("Dropping unsupported content block", "role", cm.Role, "type", fmt.Sprintf("%T", b))
+			}
+		}
+	default:
+		// Fallback: marshal to string
+		b, _ := json.Marshal(content)
+		addText(string(b))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	oaiMessages := toolMessages
+	// If content is empty or null, skip the message to avoid nulls in OAI
+	if len(oaiContents) > 0 || len(toolCalls) > 0 {
+		oaiMessages = append(oaiMessages, OAIMessage{
+			Role:      cm.Role,
+			Content:   oaiContents,
+			ToolCalls: toolCalls,
+		})
+	}
+	return oaiMessages, nil
+}
+
+// toolResultToText flattens the content of a Claude tool_result block into plain text.
+func toolResultToText(content any) string {
+	var text strings.Builder
+	switch rc := content.(type) {
+	case nil:
+	case string:
+		text.WriteString(rc)
+	case []ClaudeContentBlockText:
+		for _, item := range rc {
+			text.WriteString(item.Text + "\n")
+		}
+	case []any:
+		for _, item := range rc {
+			switch it := item.(type) {
+			case ClaudeContentBlockText:
+				text.WriteString(it.Text + "\n")
+			case ClaudeContentBlockImage:
+				// Images are forwarded separately
+			case map[string]any:
+				if it["type"] == "image" {
+					continue
+				}
+				if s, ok := it["text"].(string); ok {
+					text.WriteString(s + "\n")
+				} else {
+					b, _ := json.Marshal(it)
+					text.WriteString(string(b) + "\n")
+				}
+			case string:
+				text.WriteString(it + "\n")
+			default:
+				b, _ := json.Marshal(it)
+				text.WriteString(string(b) + "\n")
+			}
+		}
+	case map[string]any:
+		if s, ok := rc["text"].(string); ok && rc["type"] == "text" {
+			text.WriteString(s)
+		} else {
+			b, _ := json.Marshal(rc)
+			text.WriteString(string(b))
+		}
+	default:
+		b, _ := json.Marshal(rc)
+		text.WriteString(string(b))
+	}
+	return strings.TrimSpace(text.String())
+}
+
+// ConvertOAIToClaude converts an OAIRequest to a ClaudeMessagesRequest. System messages become
+// the system prompt, assistant tool_calls become tool_use blocks and consecutive tool messages
+// become tool_result blocks of a single user message. Without max_tokens or
+// max_completion_tokens MaxTokens stays zero, leaving the limit to the backend. Parameters Claude
+// cannot honor, a non-text response_format, seed and n above 1, are an error.
 func ConvertOAIToClaude(req OAIRequest) (ClaudeMessagesRequest, error) {
 	var claudeReq ClaudeMessagesRequest
+	if t, _ := req.ResponseFormat["type"].(string); req.ResponseFormat != nil && t != "text" {
+		return claudeReq, fmt.Errorf("response_format %q is not supported", t)
+	}
+	if req.Seed != nil {
+		return claudeReq, fmt.Errorf("seed is not supported")
+	}
+	if req.N != nil && *req.N > 1 {
+		return claudeReq, fmt.Errorf("n must be 1, got %d", *req.N)
+	}
 	claudeReq.Model = req.Model
 	claudeReq.MaxTokens = req.MaxTokens
+	if claudeReq.MaxTokens <= 0 {
+		claudeReq.MaxTokens = req.MaxCompletionTokens
+	}
 	claudeReq.Temperature = req.Temperature
 	claudeReq.TopP = req.TopP
 	claudeReq.TopK = req.TopK
@@ -470,24 +610,75 @@ func ConvertOAIToClaude(req OAIRequest) (ClaudeMessagesRequest, error) {
 	}
 
 	// Convert OAI messages to Claude messages
-	for _, om := range req.Messages {
+	var system []string
+	for i, om := range req.Messages {
+		switch om.Role {
+		case "system", "developer":
+			for _, c := range om.Content {
+				if c.Type == "text" && c.Text != "" {
+					system = append(system, c.Text)
+				}
+			}
+			continue
+		case "tool":
+			result := ClaudeContentBlockToolResult{
+				Type:      "tool_result",
+				ToolUseID: om.ToolCallID,
+				Content:   oaiContentText(om.Content),
+			}
+			// Results of parallel tool calls go into one user message.
+			if n := len(claudeReq.Messages); n > 0 && claudeReq.Messages[n-1].Role == "user" {
+				if blocks, ok := claudeReq.Messages[n-1].Content.([]any); ok && isToolResults(blocks) {
+					claudeReq.Messages[n-1].Content = append(blocks, result)
+					continue
+				}
+			}
+			claudeReq.Messages = append(claudeReq.Messages, ClaudeMessage{Role: "user", Content: []any{result}})
+			continue
+		}
+
 		claudeMsg := ClaudeMessage{
 			Role: om.Role,
 		}
-		// Convert OAI content array to Claude content blocks
-		var blocks []ClaudeContentBlockText
+		// Text-only messages keep their []ClaudeContentBlockText form; anything else is []any.
+		var texts []ClaudeContentBlockText
+		var blocks []any
 		for _, c := range om.Content {
-			if c.Type == "text" {
-				blocks = append(blocks, ClaudeContentBlockText{
-					Type: "text",
-					Text: c.Text,
-				})
+			switch c.Type {
+			case "text":
+				texts = append(texts, ClaudeContentBlockText{Type: "text", Text: c.Text})
+				blocks = append(blocks, ClaudeContentBlockText{Type: "text", Text: c.Text})
+			case "image_url":
+				if c.ImageURL == nil {
+					return claudeReq, fmt.Errorf("messages[%d]: image_url part has no URL", i)
+				}
+				blocks = append(blocks, ClaudeContentBlockImage{Type: "image", Source: imageSourceFromURL(c.ImageURL.URL)})
+			}
+		}
+		for _, tc := range om.ToolCalls {
+			input := map[string]any{}
+			if tc.Function.Arguments != "" {
+				if err := json.Unmarshal([]byte(tc.Function.Arguments), &input); err != nil {
+					return claudeReq, fmt.Errorf("messages[%d]: arguments of tool call %s: %w", i, tc.ID, err)
+				}
 			}
-			// Add more type handling if needed
+			blocks = append(blocks, ClaudeContentBlockToolUse{
+				Type:  "tool_use",
+				ID:    tc.ID,
+				Name:  tc.Function.Name,
+				Input: input,
+			})
+		}
+		if len(blocks) == len(texts) {
+			claudeMsg.Content = texts
+		} else {
+			claudeMsg.Content = blocks
 		}
-		claudeMsg.Content = blocks
 		claudeReq.Messages = append(claudeReq.Messages, claudeMsg)
 	}
+	if len(system) > 0 {
+		claudeReq.System = strings.Join(system, "\n")
+	}
 
 	// Convert OAI tools to Claude tools
 	if req.Tools != nil {
@@ -508,16 +699,59 @@ func ConvertOAIToClaude(req OAIRequest) (ClaudeMessagesRequest, error) {
 		claudeReq.Tools = &claudeTools
 	}
 
-	// ToolChoice direct mapping (must be *map[string]any or nil)
+	// ToolChoice conversion
 	if req.ToolChoice != nil {
-		if tc, ok := req.ToolChoice.(map[string]any); ok {
-			claudeReq.ToolChoice = &tc
+		claudeReq.ToolChoice = ConvertToolChoiceOAIToClaude(req.ToolChoice)
+	}
+
+	return claudeReq, nil
+}
+
+// ConvertToolChoiceOAIToClaude converts an OpenAI tool_choice ("auto", "none", "required" or a
+// function object) to a Claude tool_choice.
+func ConvertToolChoiceOAIToClaude(toolChoice any) *map[string]any {
+	var tc map[string]any
+	switch v := toolChoice.(type) {
+	case string:
+		switch v {
+		case "none":
+			tc = map[string]any{"type": "none"}
+		case "required":
+			tc = map[string]any{"type": "any"}
+		default:
+			tc = map[string]any{"type": "auto"}
+		}
+	case map[string]any:
+		fn, _ := v["function"].(map[string]any)
+		if name, ok := fn["name"].(string); ok {
+			tc = map[string]any{"type": "tool", "name": name}
 		} else {
-			claudeReq.ToolChoice = nil
+			tc = map[string]any{"type": "auto"}
 		}
+	default:
+		return nil
 	}
+	return &tc
+}
 
-	return claudeReq, nil
+// oaiContentText joins the text parts of OAI message content.
+func oaiContentText(content []OAIMessageContent) string {
+	var parts []string
+	for _, c := range content {
+		if c.Type == "text" {
+			parts = append(parts, c.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func isToolResults(blocks []any) bool {
+	for _, b := range blocks {
+		if _, ok := b.(ClaudeContentBlockToolResult); !ok {
+			return false
+		}
+	}
+	return len(blocks) > 0
 }
 
 // StreamEvent represents a single event in the Claude streaming protocol.
@@ -544,16 +778,32 @@ type OAIStreamChunk struct {
 	Model   string `json:"model"`
 	Choices []struct {
 		Delta struct {
-			Content   string        `json:"content,omitempty"`
-			ToolCalls []OAIToolCall `json:"tool_calls,omitempty"`
+			Content string `json:"content,omitempty"`
+			// ReasoningContent carries the reasoning of reasoning models that expose it.
+			ReasoningContent string        `json:"reasoning_content,omitempty"`
+			ToolCalls        []OAIToolCall `json:"tool_calls,omitempty"`
 		} `json:"delta"`
 		FinishReason *string `json:"finish_reason,omitempty"`
 	} `json:"choices"`
+	// Usage is sent in a final chunk without choices when the request sets
+	// stream_options.include_usage.
 	Usage *struct {
-		PromptTokens     int `json:"prompt_tokens"`
-		CompletionTokens int `json:"completion_tokens"`
-		TotalTokens      int `json:"total_tokens"`
+		PromptTokens        int `json:"prompt_tokens"`
+		CompletionTokens    int `json:"completion_tokens"`
+		TotalTokens         int `json:"total_tokens"`
+		PromptTokensDetails *struct {
+			CachedTokens int `json:"cached_tokens"`
+		} `json:"prompt_tokens_details,omitempty"`
 	} `json:"usage,omitempty"`
+	// Error is set when the upstream reports a failure inside an otherwise successful stream.
+	Error *OAIStreamError `json:"error,omitempty"`
+}
+
+// OAIStreamError is an error object sent by the upstream in place of a chunk.
+type OAIStreamError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    any    `json:"code"`
 }
 
 type OAIToolCall struct {
@@ -567,12 +817,31 @@ type OAIToolCallFunction struct {
 	Name      string `json:"name"`
 }
 
-// ConvertOAIStreamToClaudeStream reads OpenAI streaming chunks from r, converts them to Claude streaming events, and writes to w.
+// ConvertOAIStreamToClaudeStream reads OpenAI streaming chunks from r, converts them to Claude streaming events, and writes
+// them to w as Server-Sent Events.
 func ConvertOAIStreamToClaudeStream(r io.Reader, w io.Writer, model string) error {
-	encoder := json.NewEncoder(w)
+	return ConvertOAIStreamToClaudeStreamContext(context.Background(), r, w, model)
+}
+
+// ConvertOAIStreamToClaudeStreamContext is ConvertOAIStreamToClaudeStream with cancellation. When ctx is done, or
+// writing to w fails because the client went away, it stops reading, closes r if it is an io.Closer so the upstream
+// stops generating, and returns the cause.
+func ConvertOAIStreamToClaudeStreamContext(ctx context.Context, r io.Reader, w io.Writer, model string) error {
+	return ConvertOAIStreamToClaudeStreamWithOptions(ctx, r, w, model, ConvertOptions{})
+}
+
+// ConvertOAIStreamToClaudeStreamWithOptions is ConvertOAIStreamToClaudeStreamContext with the message and tool_use
+// IDs generated by opts.IDs. The upstream ID of each tool call is added to opts.ToolIDs.
+func ConvertOAIStreamToClaudeStreamWithOptions(ctx context.Context, r io.Reader, w io.Writer, model string, opts ConvertOptions) error {
+	if closer, ok := r.(io.Closer); ok {
+		// Unblock a pending read on cancellation.
+		stop := context.AfterFunc(ctx, func() { closer.Close() })
+		defer stop()
+	}
+	sse := NewSSEWriter(w)
 
 	// Send message_start event
-	messageID := fmt.Sprintf("msg_%024x", 0)
+	messageID := opts.ids().NewID(MessageIDPrefix)
 	messageStart := map[string]any{
 		"type": "message_start",
 		"message": map[string]any{
@@ -591,33 +860,61 @@ func ConvertOAIStreamToClaudeStream(r io.Reader, w io.Writer, model string) erro
 			},
 		},
 	}
-	encoder.Encode(map[string]any{"event": "message_start", "data": messageStart})
-
-	// Send content_block_start for text
-	encoder.Encode(map[string]any{
-		"event": "content_block_start",
-		"data": map[string]any{
-			"type":          "content_block_start",
-			"index":         0,
-			"content_block": map[string]any{"type": "text", "text": ""},
-		},
-	})
+	sse.WriteEvent("message_start", messageStart)
 
 	// Send ping event
-	encoder.Encode(map[string]any{"event": "ping", "data": map[string]any{"type": "ping"}})
+	sse.WriteEvent("ping", map[string]any{"type": "ping"})
 
-	var accumulatedText string
-	var textBlockClosed bool
-	var outputTokens int
+	logger := LoggerFrom(ctx)
+	if opts.Logger != nil {
+		logger = opts.Logger
+	}
+	blocks := newStreamBlocks(sse, logger)
+	blocks.ids = opts.ids()
+	blocks.toolIDs = opts.ToolIDs
+	var usage ClaudeUsage
+	var toolCallsStarted bool
+	// stopReason is set once the upstream sends a finish_reason. The message is ended when the
+	// trailing usage chunk arrives, or at the end of the stream.
+	var stopReason string
+
+	// generatedTokens estimates the upstream output so far, for logging cancellations.
+	var generatedTokens int
+	estimator := EstimatingTokenizer{}
+	cancelled := func(cause error) error {
+		logger.Info("Stream cancelled, discarding the upstream generation", "cause", cause, "generated_tokens", generatedTokens)
+		return cause
+	}
 
 	// Read line by line, strip "data: ", skip empty lines, stop at [DONE]
-	bufReader := io.Reader(r)
-	lineReader := bufio.NewReader(bufReader)
-	lastToolIndex := -1
+	lineReader := bufio.NewReader(r)
+	// streamError reports a failure to the client as an error event and returns it.
+	streamError := func(apiErr *APIError) error {
+		sse.WriteEvent("error", apiErr.Response())
+		return apiErr
+	}
+	// finish closes the open block and ends the message.
+	finish := func(stopReason string) error {
+		blocks.stop()
+		sse.WriteEvent("message_delta", map[string]any{
+			"type": "message_delta",
+			"delta": map[string]any{
+				"stop_reason":   stopReason,
+				"stop_sequence": nil,
+			},
+			"usage": usage,
+		})
+		sse.WriteEvent("message_stop", map[string]any{"type": "message_stop"})
+		return sse.Err()
+	}
+
 	for {
 		line, err := lineReader.ReadString('\n')
+		if ctx.Err() != nil {
+			return cancelled(context.Cause(ctx))
+		}
 		if err != nil && err != io.EOF {
-			return err
+			return streamError(NewAPIError(http.StatusBadGateway, "upstream stream failed: %v", err))
 		}
 		line = strings.TrimSpace(line)
 		if line == "" {
@@ -639,112 +936,54 @@ func ConvertOAIStreamToClaudeStream(r io.Reader, w io.Writer, model string) erro
 		var chunk OAIStreamChunk
 		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
 			// skip invalid lines, but log for debugging
-			fmt.Fprintf(w, "data: {\"error\": \"invalid chunk: %s\"}\n\n", err.Error())
+			logger.Warn("Skipping invalid upstream chunk", "error", err, "data", data)
 			if err == io.EOF {
 				break
 			}
 			continue
 		}
 
+		if chunk.Error != nil {
+			status := http.StatusInternalServerError
+			if chunk.Error.Code == "rate_limit_exceeded" {
+				status = http.StatusTooManyRequests
+			}
+			return streamError(NewAPIError(status, "%s", chunk.Error.Message))
+		}
+
+		if u := chunk.Usage; u != nil {
+			// OpenAI counts cached tokens as part of the prompt, Claude reports them separately.
+			usage.InputTokens = u.PromptTokens
+			if u.PromptTokensDetails != nil {
+				usage.CacheReadInputTokens = u.PromptTokensDetails.CachedTokens
+				usage.InputTokens -= u.PromptTokensDetails.CachedTokens
+			}
+			usage.OutputTokens = u.CompletionTokens
+		}
+
 		for _, choice := range chunk.Choices {
-			// Handle tool calls (OpenAI tool_calls in delta)
-			if len(choice.Delta.ToolCalls) > 0 {
-				// Close text block if open
-				if !textBlockClosed {
-					encoder.Encode(map[string]any{
-						"event": "content_block_stop",
-						"data": map[string]any{
-							"type":  "content_block_stop",
-							"index": 0,
-						},
-					})
-					textBlockClosed = true
-				}
-				for _, toolCall := range choice.Delta.ToolCalls {
-					// Start tool_use block
-					if lastToolIndex != toolCall.Index {
-						if lastToolIndex == -1 {
-							// Stop tool_use block
-							encoder.Encode(map[string]any{
-								"event": "content_block_stop",
-								"data": map[string]any{
-									"type":  "content_block_stop",
-									"index": lastToolIndex,
-								},
-							})
-						}
-						lastToolIndex = toolCall.Index
-						encoder.Encode(map[string]any{
-							"event": "content_block_start",
-							"data": map[string]any{
-								"type":  "content_block_start",
-								"index": toolCall.Index,
-								"content_block": map[string]any{
-									"type":  "tool_use",
-									"id":    toolCall.Id,
-									"name":  toolCall.Function.Name,
-									"input": map[string]any{},
-								},
-							},
-						})
-					}
-					// Send input_json_delta
-					encoder.Encode(map[string]any{
-						"event": "content_block_delta",
-						"data": map[string]any{
-							"type":  "content_block_delta",
-							"index": toolCall.Index,
-							"delta": map[string]any{
-								"type":         "input_json_delta",
-								"partial_json": toolCall.Function.Arguments,
-								"index":        toolCall.Index,
-							},
-						},
-					})
+			generatedTokens += estimator.CountTokens(choice.Delta.ReasoningContent)
+			generatedTokens += estimator.CountTokens(choice.Delta.Content)
 
-				}
+			// Handle reasoning and text deltas. Text after a tool call opens a new text block,
+			// keeping the order the upstream produced.
+			if choice.Delta.ReasoningContent != "" {
+				blocks.thinking(choice.Delta.ReasoningContent)
+			}
+			if choice.Delta.Content != "" {
+				blocks.text(choice.Delta.Content)
 			}
 
-			// Handle text deltas
-			if choice.Delta.Content != "" && !textBlockClosed {
-				accumulatedText += choice.Delta.Content
-				encoder.Encode(map[string]any{
-					"event": "content_block_delta",
-					"data": map[string]any{
-						"type":  "content_block_delta",
-						"index": 0,
-						"delta": map[string]any{
-							"type": "text_delta",
-							"text": choice.Delta.Content,
-						},
-					},
-				})
+			// Handle tool calls (OpenAI tool_calls in delta)
+			for _, toolCall := range choice.Delta.ToolCalls {
+				generatedTokens += estimator.CountTokens(toolCall.Function.Arguments)
+				toolCallsStarted = true
+				blocks.toolCall(toolCall)
 			}
 
 			// Handle finish_reason
 			if choice.FinishReason != nil {
-				if lastToolIndex != -1 {
-					// Stop tool_use block
-					encoder.Encode(map[string]any{
-						"event": "content_block_stop",
-						"data": map[string]any{
-							"type":  "content_block_stop",
-							"index": lastToolIndex,
-						},
-					})
-				}
-				if !textBlockClosed {
-					textBlockClosed = true
-					encoder.Encode(map[string]any{
-						"event": "content_block_stop",
-						"data": map[string]any{
-							"type":  "content_block_stop",
-							"index": 0,
-						},
-					})
-				}
-
-				stopReason := "end_turn"
+				stopReason = "end_turn"
 				switch *choice.FinishReason {
 				case "length":
 					stopReason = "max_tokens"
@@ -753,64 +992,139 @@ func ConvertOAIStreamToClaudeStream(r io.Reader, w io.Writer, model string) erro
 				case "stop":
 					stopReason = "end_turn"
 				}
-
-				encoder.Encode(map[string]any{
-					"event": "message_delta",
-					"data": map[string]any{
-						"type": "message_delta",
-						"delta": map[string]any{
-							"stop_reason":   stopReason,
-							"stop_sequence": nil,
-						},
-						"usage": map[string]any{
-							"output_tokens": outputTokens,
-						},
-					},
-				})
-
-				encoder.Encode(map[string]any{
-					"event": "message_stop",
-					"data":  map[string]any{"type": "message_stop"},
-				})
-
-				// Send [DONE] marker
-				fmt.Fprint(w, "data: [DONE]\n\n")
-				return nil
+				// Close the last block now; the message ends once usage is known.
+				blocks.stop()
 			}
 		}
+		if stopReason != "" && chunk.Usage != nil {
+			return finish(stopReason)
+		}
+		// Stop early if the client has gone away
+		if err := sse.Err(); err != nil {
+			return cancelled(err)
+		}
 		if err == io.EOF {
 			break
 		}
 	}
 
-	// If we never saw a finish_reason, close the text block and send message_stop
-	if !textBlockClosed {
-		encoder.Encode(map[string]any{
-			"event": "content_block_stop",
-			"data": map[string]any{
-				"type":  "content_block_stop",
-				"index": 0,
-			},
-		})
-		encoder.Encode(map[string]any{
-			"event": "message_delta",
-			"data": map[string]any{
-				"type": "message_delta",
-				"delta": map[string]any{
-					"stop_reason":   "end_turn",
-					"stop_sequence": nil,
-				},
-				"usage": map[string]any{
-					"output_tokens": outputTokens,
-				},
-			},
+	// If we never saw a finish_reason, end the message anyway
+	if stopReason == "" {
+		stopReason = "end_turn"
+		if toolCallsStarted {
+			stopReason = "tool_use"
+		}
+	}
+	return finish(stopReason)
+}
+
+// streamBlocks is the content block state machine of a converted stream. Claude streams send
+// one block at a time: content_block_start, its deltas, then content_block_stop, with indices
+// counting up from 0 in the order the blocks appear. Upstream text, reasoning and tool call
+// deltas are mapped onto that, opening a new block whenever the kind of content changes.
+type streamBlocks struct {
+	sse *SSEWriter
+	// next is the index of the next block to open.
+	next int
+	// open is the index of the open block, or -1.
+	open     int
+	openType string
+	// tools maps upstream tool call indices to their Claude tool_use block, which stays
+	// open until another block starts.
+	tools   map[int]streamTool
+	ids     IDGenerator
+	toolIDs *ToolIDMap
+	logger  *slog.Logger
+}
+
+type streamTool struct {
+	index int    // Claude block index
+	id    string // upstream tool call ID
+}
+
+func newStreamBlocks(sse *SSEWriter, logger *slog.Logger) *streamBlocks {
+	return &streamBlocks{sse: sse, logger: logger, open: -1, tools: map[int]streamTool{}, ids: RandomIDs{}}
+}
+
+// start closes the open block and opens a new one.
+func (b *streamBlocks) start(block map[string]any) int {
+	b.stop()
+	b.open = b.next
+	b.openType, _ = block["type"].(string)
+	b.next++
+	b.sse.WriteEvent("content_block_start", map[string]any{
+		"type":          "content_block_start",
+		"index":         b.open,
+		"content_block": block,
+	})
+	return b.open
+}
+
+// stop closes the open block, if any.
+func (b *streamBlocks) stop() {
+	if b.open < 0 {
+		return
+	}
+	b.sse.WriteEvent("content_block_stop", map[string]any{
+		"type":  "content_block_stop",
+		"index": b.open,
+	})
+	b.open = -1
+	b.openType = ""
+}
+
+func (b *streamBlocks) delta(delta map[string]any) {
+	b.sse.WriteEvent("content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": b.open,
+		"delta": delta,
+	})
+}
+
+// text appends to the open text block, opening one if needed.
+func (b *streamBlocks) text(text string) {
+	if b.openType != "text" {
+		b.start(map[string]any{"type": "text", "text": ""})
+	}
+	b.delta(map[string]any{"type": "text_delta", "text": text})
+}
+
+// thinking appends to the open thinking block, opening one if needed.
+func (b *streamBlocks) thinking(thinking string) {
+	if b.openType != "thinking" {
+		b.start(map[string]any{"type": "thinking", "thinking": "", "signature": ""})
+	}
+	b.delta(map[string]any{"type": "thinking_delta", "thinking": thinking})
+}
+
+// toolCall handles one upstream tool call delta. The first delta of a call (a new index, or
+// a new ID at a known index) opens its tool_use block with a new Claude ID; later ones stream
+// the arguments.
+func (b *streamBlocks) toolCall(tc OAIToolCall) {
+	tool, known := b.tools[tc.Index]
+	if !known || (tc.Id != "" && tc.Id != tool.id) {
+		tool = streamTool{id: tc.Id}
+		claudeID := b.ids.NewID(ToolUseIDPrefix)
+		b.toolIDs.Add(claudeID, tc.Id)
+		tool.index = b.start(map[string]any{
+			"type":  "tool_use",
+			"id":    claudeID,
+			"name":  tc.Function.Name,
+			"input": map[string]any{},
 		})
-		encoder.Encode(map[string]any{
-			"event": "message_stop",
-			"data":  map[string]any{"type": "message_stop"},
+		b.tools[tc.Index] = tool
+	} else if b.open != tool.index {
+		// Claude blocks cannot be reopened, so arguments for a call that is no longer the
+		// open block cannot be delivered.
+		if tc.Function.Arguments != "" {
+			b.logger.Warn("Dropping arguments for interleaved tool call", "index", tc.Index)
+		}
+		return
+	}
+	if tc.Function.Arguments != "" {
+		b.delta(map[string]any{
+			"type":         "input_json_delta",
+			"partial_json": tc.Function.Arguments,
 		})
-		fmt.Fprint(w, "data: [DONE]\n\n")
 	}
-
-	return nil
 }
@@ -2,11 +2,15 @@ package claudecodeproxy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestConvertClaudeToOAIAndBack(t *testing.T) {
@@ -65,7 +69,7 @@ func TestConvertToolChoiceClaudeToOAI(t *testing.T) {
 		{
 			name:  "any",
 			input: map[string]any{"type": "any"},
-			want:  "any",
+			want:  "required",
 		},
 		{
 			name:  "tool with name",
@@ -98,15 +102,30 @@ func TestConvertToolChoiceClaudeToOAI(t *testing.T) {
 
 func TestNoBlankTextBlockInClaudeResponse(t *testing.T) {
 	// Simulate a Claude stream with a blank text block and a tool_use block
-	claudeStream := `
-{"event":"message_start","data":{"type":"message_start","message":{"id":"msg_000000000000000000000000","type":"message","role":"assistant","model":"claude-3-7-sonnet-20250219","content":[],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":0,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":0}}}
-{"event":"content_block_start","data":{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}}
-{"event":"content_block_stop","data":{"type":"content_block_stop","index":0}}
-{"event":"content_block_start","data":{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"call_aNta7TNMC8U07NRUaIe7GLNl","name":"Bash","input":{}}}}
-{"event":"content_block_delta","data":{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"command\":\"echo hello world\",\"description\":\"Echoes 'hello world' to the terminal\"}"}}}
-{"event":"content_block_stop","data":{"type":"content_block_stop","index":1}}
-{"event":"message_delta","data":{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":0}}}
-{"event":"message_stop","data":{"type":"message_stop"}}
+	claudeStream := `event: message_start
+data: {"type":"message_start","message":{"id":"msg_000000000000000000000000","type":"message","role":"assistant","model":"claude-3-7-sonnet-20250219","content":[],"stop_reason":null,"stop_sequence":null,"usage":{"input_tokens":0,"cache_creation_input_tokens":0,"cache_read_input_tokens":0,"output_tokens":0}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"call_aNta7TNMC8U07NRUaIe7GLNl","name":"Bash","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"command\":\"echo hello world\",\"description\":\"Echoes 'hello world' to the terminal\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":1}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":0}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
 `
 	r := strings.NewReader(claudeStream)
 	resp, err := ParseClaudeStreamToResponse(r)
@@ -173,8 +192,8 @@ data: [DONE]
 			if tub.Name != "Bash" {
 				t.Errorf("tool_use block name mismatch: got %v, want Bash", tub.Name)
 			}
-			if tub.ID != "call_wt8tlK30LhNXN1NENYUGa2zA" {
-				t.Errorf("tool_use block id mismatch: got %v, want call_wt8tlK30LhNXN1NENYUGa2zA", tub.ID)
+			if !strings.HasPrefix(tub.ID, ToolUseIDPrefix) || len(tub.ID) != len("toolu_01")+22 {
+				t.Errorf("tool_use block id mismatch: got %v, want a generated toolu_ ID", tub.ID)
 			}
 			// Check that the input field is set and matches the expected arguments
 			if tub.Input["command"] != "echo hello world" {
@@ -211,7 +230,7 @@ data: [DONE]
 		t.Fatalf("ConvertOAIStreamToClaudeStream error: %v", err)
 	}
 	out := w.String()
-	if !strings.Contains(out, `"event":"content_block_delta"`) && !strings.Contains(out, `"event": "content_block_delta"`) {
+	if !strings.Contains(out, "event: content_block_delta\ndata: ") {
 		t.Errorf("Expected content_block_delta event in output, got: %s", out)
 	}
 	if !strings.Contains(out, "Hello, ") || !strings.Contains(out, "world!") {
@@ -286,3 +305,671 @@ data: [DONE]
 		t.Errorf("Expected tool_calls/tool_use finish_reason in output, got: %s", out)
 	}
 }
+
+func TestConvertOAIStreamToClaudeStream_UpstreamError(t *testing.T) {
+	oaiStream := `data: {"id":"cmpl-abc","object":"chat.completion.chunk","created":123,"model":"gpt-4o","choices":[{"delta":{"content":"Hel"}}]}
+
+data: {"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}
+
+`
+	var w bytes.Buffer
+	err := ConvertOAIStreamToClaudeStream(strings.NewReader(oaiStream), &w, "claude-3-sonnet-20240229")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an *APIError, got %v", err)
+	}
+	if apiErr.Status != 429 || apiErr.Type != "rate_limit_error" {
+		t.Errorf("Error mismatch: got %d %s, want 429 rate_limit_error", apiErr.Status, apiErr.Type)
+	}
+	out := w.String()
+	if !strings.Contains(out, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"rate_limit_error\",\"message\":\"Rate limit reached\"}}") {
+		t.Errorf("Expected an error event in output, got: %s", out)
+	}
+
+	// The buffered (non-stream) path reports the same error.
+	_, err = ParseClaudeStreamToResponse(&w)
+	if !errors.As(err, &apiErr) || apiErr.Status != 429 {
+		t.Errorf("ParseClaudeStreamToResponse error mismatch: got %v", err)
+	}
+}
+
+func TestConvertOAIStreamToClaudeStreamContext_Cancel(t *testing.T) {
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- ConvertOAIStreamToClaudeStreamContext(ctx, pr, &out, "claude-3-sonnet-20240229")
+	}()
+
+	// The upstream sends one chunk and then stalls.
+	io.WriteString(pw, `data: {"id":"cmpl-abc","object":"chat.completion.chunk","created":123,"model":"gpt-4o","choices":[{"delta":{"content":"Hello there"}}]}`+"\n\n")
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Error mismatch: got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Conversion did not stop after cancellation")
+	}
+	// The upstream body has been closed.
+	if _, err := io.WriteString(pw, "data: [DONE]\n"); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Expected the upstream reader to be closed, write returned %v", err)
+	}
+	if strings.Contains(out.String(), "message_stop") {
+		t.Errorf("Cancelled stream should not be completed, got: %s", out.String())
+	}
+}
+
+// limitedWriter accepts limit bytes and then fails, like a disconnected client.
+type limitedWriter struct{ limit int }
+
+func (f *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > f.limit {
+		return 0, io.ErrClosedPipe
+	}
+	f.limit -= len(p)
+	return len(p), nil
+}
+
+func TestConvertOAIStreamToClaudeStream_ClientGone(t *testing.T) {
+	chunk := `data: {"id":"cmpl-abc","object":"chat.completion.chunk","created":123,"model":"gpt-4o","choices":[{"delta":{"content":"x"}}]}` + "\n\n"
+	upstream := strings.NewReader(strings.Repeat(chunk, 100))
+	err := ConvertOAIStreamToClaudeStream(upstream, &limitedWriter{limit: 1024}, "claude-3-sonnet-20240229")
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Error mismatch: got %v, want io.ErrClosedPipe", err)
+	}
+	if upstream.Len() == 0 {
+		t.Error("Expected the conversion to stop before reading the whole upstream stream")
+	}
+}
+
+func TestConvertClaudeToOAI_SystemString(t *testing.T) {
+	claudeReq := ClaudeMessagesRequest{
+		Model:     "claude-3-sonnet-20240229",
+		MaxTokens: 256,
+		System:    "You are a helpful assistant.",
+		Messages: []ClaudeMessage{
+			{Role: "user", Content: "Hi"},
+		},
+	}
+
+	oaiReq, err := ConvertClaudeToOAI(claudeReq)
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAI error: %v", err)
+	}
+	if len(oaiReq.Messages) != 2 {
+		t.Fatalf("Expected system and user messages, got %+v", oaiReq.Messages)
+	}
+	sys := oaiReq.Messages[0]
+	if sys.Role != "system" || len(sys.Content) != 1 || sys.Content[0].Text != "You are a helpful assistant." {
+		t.Errorf("System message mismatch: got %+v", sys)
+	}
+	if oaiReq.Messages[1].Role != "user" {
+		t.Errorf("Expected user message after system message, got %s", oaiReq.Messages[1].Role)
+	}
+}
+
+func TestConvertClaudeToOAI_SystemBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		system any
+		want   string
+	}{
+		{
+			name: "typed blocks",
+			system: []ClaudeSystemContent{
+				{Type: "text", Text: "First block."},
+				{Type: "text", Text: "Second block.", CacheControl: map[string]any{"type": "ephemeral"}},
+			},
+			want: "First block.\nSecond block.",
+		},
+		{
+			name: "decoded json blocks",
+			system: []any{
+				map[string]any{"type": "text", "text": "First block."},
+				map[string]any{"type": "text", "text": "Second block.", "cache_control": map[string]any{"type": "ephemeral"}},
+			},
+			want: "First block.\nSecond block.",
+		},
+		{
+			name:   "empty blocks",
+			system: []any{},
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oaiReq, err := ConvertClaudeToOAI(ClaudeMessagesRequest{
+				Model:     "claude-3-sonnet-20240229",
+				MaxTokens: 256,
+				System:    tt.system,
+				Messages:  []ClaudeMessage{{Role: "user", Content: "Hi"}},
+			})
+			if err != nil {
+				t.Fatalf("ConvertClaudeToOAI error: %v", err)
+			}
+			if tt.want == "" {
+				if len(oaiReq.Messages) != 1 || oaiReq.Messages[0].Role != "user" {
+					t.Errorf("Expected no system message, got %+v", oaiReq.Messages)
+				}
+				return
+			}
+			sys := oaiReq.Messages[0]
+			if sys.Role != "system" || len(sys.Content) != 1 || sys.Content[0].Text != tt.want {
+				t.Errorf("System message mismatch: got %+v, want %q", sys, tt.want)
+			}
+			body, _ := json.Marshal(oaiReq)
+			if strings.Contains(string(body), "cache_control") {
+				t.Errorf("cache_control leaked into OAI request: %s", body)
+			}
+		})
+	}
+}
+
+func TestConvertClaudeToOAI_SystemFromJSON(t *testing.T) {
+	body := `{"model":"claude-3-sonnet-20240229","max_tokens":256,"system":[{"type":"text","text":"Rules.","cache_control":{"type":"ephemeral"}},{"type":"text","text":"Env info."}],"messages":[{"role":"user","content":"Hi"}]}`
+	var claudeReq ClaudeMessagesRequest
+	if err := json.Unmarshal([]byte(body), &claudeReq); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	oaiReq, err := ConvertClaudeToOAI(claudeReq)
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAI error: %v", err)
+	}
+	if oaiReq.Messages[0].Role != "system" || oaiReq.Messages[0].Content[0].Text != "Rules.\nEnv info." {
+		t.Errorf("System message mismatch: got %+v", oaiReq.Messages[0])
+	}
+}
+
+func TestConvertClaudeToOAI_ToolHistory(t *testing.T) {
+	body := `{
+		"model": "claude-3-sonnet-20240229",
+		"max_tokens": 256,
+		"messages": [
+			{"role": "user", "content": "List files"},
+			{"role": "assistant", "content": [
+				{"type": "text", "text": "Running ls."},
+				{"type": "tool_use", "id": "toolu_1", "name": "Bash", "input": {"command": "ls"}},
+				{"type": "tool_use", "id": "toolu_2", "name": "Bash", "input": {"command": "pwd"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": "a.go\nb.go"},
+				{"type": "tool_result", "tool_use_id": "toolu_2", "content": [{"type": "text", "text": "/root"}]},
+				{"type": "text", "text": "Thanks"}
+			]}
+		]
+	}`
+	var claudeReq ClaudeMessagesRequest
+	if err := json.Unmarshal([]byte(body), &claudeReq); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	oaiReq, err := ConvertClaudeToOAI(claudeReq)
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAI error: %v", err)
+	}
+
+	var roles []string
+	for _, m := range oaiReq.Messages {
+		roles = append(roles, m.Role)
+	}
+	wantRoles := []string{"user", "assistant", "tool", "tool", "user"}
+	if !reflect.DeepEqual(roles, wantRoles) {
+		t.Fatalf("Role sequence mismatch: got %v, want %v", roles, wantRoles)
+	}
+
+	assistant := oaiReq.Messages[1]
+	if len(assistant.Content) != 1 || assistant.Content[0].Text != "Running ls." {
+		t.Errorf("Assistant text mismatch: got %+v", assistant.Content)
+	}
+	if len(assistant.ToolCalls) != 2 {
+		t.Fatalf("Expected 2 tool calls, got %+v", assistant.ToolCalls)
+	}
+	tc := assistant.ToolCalls[0]
+	if tc.ID != "toolu_1" || tc.Type != "function" || tc.Function.Name != "Bash" || tc.Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("Tool call mismatch: got %+v", tc)
+	}
+
+	if m := oaiReq.Messages[2]; m.ToolCallID != "toolu_1" || m.Content[0].Text != "a.go\nb.go" {
+		t.Errorf("First tool message mismatch: got %+v", m)
+	}
+	if m := oaiReq.Messages[3]; m.ToolCallID != "toolu_2" || m.Content[0].Text != "/root" {
+		t.Errorf("Second tool message mismatch: got %+v", m)
+	}
+	if m := oaiReq.Messages[4]; len(m.Content) != 1 || m.Content[0].Text != "Thanks" {
+		t.Errorf("Trailing user message mismatch: got %+v", m)
+	}
+}
+
+func TestConvertClaudeToOAI_ToolCallOnlyAssistant(t *testing.T) {
+	claudeReq := ClaudeMessagesRequest{
+		Model:     "claude-3-sonnet-20240229",
+		MaxTokens: 256,
+		Messages: []ClaudeMessage{
+			{Role: "user", Content: "What is 2+2?"},
+			{Role: "assistant", Content: []any{
+				ClaudeContentBlockToolUse{Type: "tool_use", ID: "toolu_1", Name: "calculator", Input: map[string]any{"expression": "2+2"}},
+			}},
+			{Role: "user", Content: []any{
+				ClaudeContentBlockToolResult{Type: "tool_result", ToolUseID: "toolu_1", Content: "4"},
+			}},
+		},
+	}
+	oaiReq, err := ConvertClaudeToOAI(claudeReq)
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAI error: %v", err)
+	}
+	if len(oaiReq.Messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %+v", oaiReq.Messages)
+	}
+	body, _ := json.Marshal(oaiReq.Messages[1])
+	want := `{"role":"assistant","content":null,"tool_calls":[{"id":"toolu_1","type":"function","function":{"arguments":"{\"expression\":\"2+2\"}","name":"calculator"}}]}`
+	if string(body) != want {
+		t.Errorf("Assistant message JSON mismatch:\ngot  %s\nwant %s", body, want)
+	}
+	body, _ = json.Marshal(oaiReq.Messages[2])
+	want = `{"role":"tool","content":[{"type":"text","text":"4"}],"tool_call_id":"toolu_1"}`
+	if string(body) != want {
+		t.Errorf("Tool message JSON mismatch:\ngot  %s\nwant %s", body, want)
+	}
+}
+
+func TestConvertClaudeToOAI_Thinking(t *testing.T) {
+	var req ClaudeMessagesRequest
+	err := json.Unmarshal([]byte(`{
+		"model": "claude-sonnet-4-20250514",
+		"max_tokens": 16000,
+		"temperature": 0.5,
+		"thinking": {"type": "enabled", "budget_tokens": 10000},
+		"messages": [
+			{"role": "user", "content": "Hi"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "The user greets me.", "signature": "sig"},
+				{"type": "text", "text": "Hello!"}
+			]},
+			{"role": "user", "content": "What is 2+2?"}
+		]
+	}`), &req)
+	if err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if !req.Thinking.IsEnabled() || req.Thinking.BudgetTokens != 10000 {
+		t.Fatalf("Thinking config mismatch: got %+v", req.Thinking)
+	}
+	oaiReq, err := ConvertClaudeToOAI(req)
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAI error: %v", err)
+	}
+	if oaiReq.Model != "o4-mini" {
+		t.Errorf("Model mismatch: got %q, want o4-mini", oaiReq.Model)
+	}
+	if oaiReq.ReasoningEffort != "medium" {
+		t.Errorf("Reasoning effort mismatch: got %q, want medium", oaiReq.ReasoningEffort)
+	}
+	if oaiReq.Temperature != nil {
+		t.Errorf("Temperature should be dropped for reasoning models, got %v", *oaiReq.Temperature)
+	}
+	assistant := oaiReq.Messages[1]
+	if len(assistant.Content) != 1 || assistant.Content[0].Text != "Hello!" {
+		t.Errorf("Thinking blocks should be dropped from history, got %+v", assistant.Content)
+	}
+}
+
+func TestReasoningEffortForBudget(t *testing.T) {
+	tests := []struct {
+		budget int
+		want   string
+	}{
+		{1024, "low"},
+		{4000, "low"},
+		{10000, "medium"},
+		{31999, "high"},
+	}
+	for _, tt := range tests {
+		if got := ReasoningEffortForBudget(tt.budget); got != tt.want {
+			t.Errorf("ReasoningEffortForBudget(%d) mismatch: got %q, want %q", tt.budget, got, tt.want)
+		}
+	}
+}
+
+func TestConvertOAIStreamToClaudeStream_Reasoning(t *testing.T) {
+	oaiStream := `data: {"id":"cmpl-abc","object":"chat.completion.chunk","created":123,"model":"o4-mini","choices":[{"delta":{"reasoning_content":"Two plus two "}}]}
+
+data: {"id":"cmpl-abc","object":"chat.completion.chunk","created":123,"model":"o4-mini","choices":[{"delta":{"reasoning_content":"is four."}}]}
+
+data: {"id":"cmpl-abc","object":"chat.completion.chunk","created":123,"model":"o4-mini","choices":[{"delta":{"content":"4"}}]}
+
+data: {"id":"cmpl-abc","object":"chat.completion.chunk","created":123,"model":"o4-mini","choices":[{"delta":{},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`
+	var w bytes.Buffer
+	if err := ConvertOAIStreamToClaudeStream(strings.NewReader(oaiStream), &w, "claude-sonnet-4-20250514"); err != nil {
+		t.Fatalf("ConvertOAIStreamToClaudeStream error: %v", err)
+	}
+	out := w.String()
+	for _, want := range []string{
+		`{"content_block":{"signature":"","thinking":"","type":"thinking"},"index":0,"type":"content_block_start"}`,
+		`{"delta":{"thinking":"Two plus two ","type":"thinking_delta"},"index":0,"type":"content_block_delta"}`,
+		`{"content_block":{"text":"","type":"text"},"index":1,"type":"content_block_start"}`,
+		`{"delta":{"text":"4","type":"text_delta"},"index":1,"type":"content_block_delta"}`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in output, got: %s", want, out)
+		}
+	}
+
+	resp, err := ParseClaudeStreamToResponse(&w)
+	if err != nil {
+		t.Fatalf("ParseClaudeStreamToResponse error: %v", err)
+	}
+	want := []any{
+		&ClaudeContentBlockThinking{Type: "thinking", Thinking: "Two plus two is four."},
+		&ClaudeContentBlockText{Type: "text", Text: "4"},
+	}
+	if !reflect.DeepEqual(resp.Content, want) {
+		t.Errorf("Content mismatch: got %+v, want %+v", resp.Content, want)
+	}
+}
+
+// summarizeClaudeStream reduces a Claude stream to one line per content block event, e.g.
+// "start 1 tool_use toolu_000000000000000000000002", "delta 1 {\"a\":", "stop 1".
+func summarizeClaudeStream(t *testing.T, stream string) []string {
+	t.Helper()
+	var got []string
+	reader := NewSSEReader(strings.NewReader(stream))
+	for {
+		ev, err := reader.ReadEvent()
+		if err == io.EOF {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("ReadEvent error: %v", err)
+		}
+		var data struct {
+			Index        int            `json:"index"`
+			ContentBlock map[string]any `json:"content_block"`
+			Delta        map[string]any `json:"delta"`
+		}
+		json.Unmarshal([]byte(ev.Data), &data)
+		switch ev.Event {
+		case "content_block_start":
+			line := fmt.Sprintf("start %d %s", data.Index, data.ContentBlock["type"])
+			if id, ok := data.ContentBlock["id"].(string); ok {
+				line += " " + id
+			}
+			got = append(got, line)
+		case "content_block_delta":
+			var text any
+			for _, key := range []string{"text", "thinking", "partial_json"} {
+				if v, ok := data.Delta[key]; ok {
+					text = v
+				}
+			}
+			got = append(got, fmt.Sprintf("delta %d %s", data.Index, text))
+		case "content_block_stop":
+			got = append(got, fmt.Sprintf("stop %d", data.Index))
+		case "message_delta":
+			got = append(got, fmt.Sprintf("message_delta %s", data.Delta["stop_reason"]))
+		}
+	}
+}
+
+// oaiChunks builds an upstream stream from chunk deltas, ending with finishReason.
+func oaiChunks(finishReason string, deltas ...string) string {
+	var b strings.Builder
+	for _, d := range deltas {
+		fmt.Fprintf(&b, "data: {\"id\":\"cmpl-abc\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4.1\",\"choices\":[{\"delta\":%s}]}\n\n", d)
+	}
+	fmt.Fprintf(&b, "data: {\"id\":\"cmpl-abc\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4.1\",\"choices\":[{\"delta\":{},\"finish_reason\":%q}]}\n\ndata: [DONE]\n\n", finishReason)
+	return b.String()
+}
+
+func TestConvertOAIStreamToClaudeStream_ParallelToolCalls(t *testing.T) {
+	tests := []struct {
+		name        string
+		upstream    string
+		wantEvents  []string
+		wantContent []any
+	}{
+		{
+			name: "TwoToolCalls",
+			upstream: oaiChunks("tool_calls",
+				`{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"Read","arguments":""}}]}`,
+				`{"tool_calls":[{"index":0,"function":{"arguments":"{\"path\":"}}]}`,
+				`{"tool_calls":[{"index":0,"function":{"arguments":"\"a.go\"}"}}]}`,
+				`{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"Read","arguments":""}}]}`,
+				`{"tool_calls":[{"index":1,"function":{"arguments":"{\"path\":\"b.go\"}"}}]}`,
+			),
+			wantEvents: []string{
+				"start 0 tool_use " + testToolID(2), `delta 0 {"path":`, `delta 0 "a.go"}`, "stop 0",
+				"start 1 tool_use " + testToolID(3), `delta 1 {"path":"b.go"}`, "stop 1",
+				"message_delta tool_use",
+			},
+			wantContent: []any{
+				&ClaudeContentBlockToolUse{Type: "tool_use", ID: testToolID(2), Name: "Read", Input: map[string]any{"path": "a.go"}},
+				&ClaudeContentBlockToolUse{Type: "tool_use", ID: testToolID(3), Name: "Read", Input: map[string]any{"path": "b.go"}},
+			},
+		},
+		{
+			name: "TextThenThreeToolCalls",
+			upstream: oaiChunks("tool_calls",
+				`{"content":"Checking."}`,
+				`{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"A","arguments":"{}"}}]}`,
+				`{"tool_calls":[{"index":1,"id":"call_2","function":{"name":"B","arguments":"{}"}}]}`,
+				`{"tool_calls":[{"index":2,"id":"call_3","function":{"name":"C","arguments":"{\"x\":1}"}}]}`,
+			),
+			wantEvents: []string{
+				"start 0 text", "delta 0 Checking.", "stop 0",
+				"start 1 tool_use " + testToolID(2), "delta 1 {}", "stop 1",
+				"start 2 tool_use " + testToolID(3), "delta 2 {}", "stop 2",
+				`start 3 tool_use ` + testToolID(4), `delta 3 {"x":1}`, "stop 3",
+				"message_delta tool_use",
+			},
+			wantContent: []any{
+				&ClaudeContentBlockText{Type: "text", Text: "Checking."},
+				&ClaudeContentBlockToolUse{Type: "tool_use", ID: testToolID(2), Name: "A", Input: map[string]any{}},
+				&ClaudeContentBlockToolUse{Type: "tool_use", ID: testToolID(3), Name: "B", Input: map[string]any{}},
+				&ClaudeContentBlockToolUse{Type: "tool_use", ID: testToolID(4), Name: "C", Input: map[string]any{"x": 1.0}},
+			},
+		},
+		{
+			// Some OpenAI-compatible upstreams send every call complete, in one chunk and without an index.
+			name: "CompleteCallsInOneChunk",
+			upstream: oaiChunks("tool_calls",
+				`{"tool_calls":[{"id":"call_1","function":{"name":"A","arguments":"{\"n\":1}"}},{"id":"call_2","function":{"name":"A","arguments":"{\"n\":2}"}}]}`,
+			),
+			wantEvents: []string{
+				"start 0 tool_use " + testToolID(2), `delta 0 {"n":1}`, "stop 0",
+				"start 1 tool_use " + testToolID(3), `delta 1 {"n":2}`, "stop 1",
+				"message_delta tool_use",
+			},
+			wantContent: []any{
+				&ClaudeContentBlockToolUse{Type: "tool_use", ID: testToolID(2), Name: "A", Input: map[string]any{"n": 1.0}},
+				&ClaudeContentBlockToolUse{Type: "tool_use", ID: testToolID(3), Name: "A", Input: map[string]any{"n": 2.0}},
+			},
+		},
+		{
+			name: "ReasoningThenToolCall",
+			upstream: oaiChunks("tool_calls",
+				`{"reasoning_content":"Need the file."}`,
+				`{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"Read","arguments":"{}"}}]}`,
+			),
+			wantEvents: []string{
+				"start 0 thinking", "delta 0 Need the file.", "stop 0",
+				"start 1 tool_use " + testToolID(2), "delta 1 {}", "stop 1",
+				"message_delta tool_use",
+			},
+			wantContent: []any{
+				&ClaudeContentBlockThinking{Type: "thinking", Thinking: "Need the file."},
+				&ClaudeContentBlockToolUse{Type: "tool_use", ID: testToolID(2), Name: "Read", Input: map[string]any{}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w bytes.Buffer
+			if err := ConvertOAIStreamToClaudeStreamWithOptions(context.Background(), strings.NewReader(tt.upstream), &w, "claude-sonnet-4-20250514", ConvertOptions{IDs: &SequentialIDs{}}); err != nil {
+				t.Fatalf("ConvertOAIStreamToClaudeStream error: %v", err)
+			}
+			if got := summarizeClaudeStream(t, w.String()); !reflect.DeepEqual(got, tt.wantEvents) {
+				t.Errorf("Events mismatch:\ngot  %q\nwant %q", got, tt.wantEvents)
+			}
+			resp, err := ParseClaudeStreamToResponse(&w)
+			if err != nil {
+				t.Fatalf("ParseClaudeStreamToResponse error: %v", err)
+			}
+			if !reflect.DeepEqual(resp.Content, tt.wantContent) {
+				t.Errorf("Content mismatch: got %+v, want %+v", resp.Content, tt.wantContent)
+			}
+			if resp.StopReason == nil || *resp.StopReason != "tool_use" {
+				t.Errorf("Stop reason mismatch: got %v, want tool_use", resp.StopReason)
+			}
+		})
+	}
+}
+
+func TestConvertOAIStreamToClaudeStream_InterleavedText(t *testing.T) {
+	upstream := oaiChunks("tool_calls",
+		`{"content":"First I'll read it."}`,
+		`{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"Read","arguments":"{\"path\":\"a.go\"}"}}]}`,
+		`{"content":"Then "}`,
+		`{"content":"list the dir."}`,
+		`{"tool_calls":[{"index":1,"id":"call_2","function":{"name":"LS","arguments":"{}"}}]}`,
+		`{"content":"Done."}`,
+	)
+	var w bytes.Buffer
+	if err := ConvertOAIStreamToClaudeStreamWithOptions(context.Background(), strings.NewReader(upstream), &w, "claude-sonnet-4-20250514", ConvertOptions{IDs: &SequentialIDs{}}); err != nil {
+		t.Fatalf("ConvertOAIStreamToClaudeStream error: %v", err)
+	}
+	wantEvents := []string{
+		"start 0 text", "delta 0 First I'll read it.", "stop 0",
+		"start 1 tool_use " + testToolID(2), `delta 1 {"path":"a.go"}`, "stop 1",
+		"start 2 text", "delta 2 Then ", "delta 2 list the dir.", "stop 2",
+		"start 3 tool_use " + testToolID(3), "delta 3 {}", "stop 3",
+		"start 4 text", "delta 4 Done.", "stop 4",
+		"message_delta tool_use",
+	}
+	if got := summarizeClaudeStream(t, w.String()); !reflect.DeepEqual(got, wantEvents) {
+		t.Errorf("Events mismatch:\ngot  %q\nwant %q", got, wantEvents)
+	}
+
+	resp, err := ParseClaudeStreamToResponse(&w)
+	if err != nil {
+		t.Fatalf("ParseClaudeStreamToResponse error: %v", err)
+	}
+	want := []any{
+		&ClaudeContentBlockText{Type: "text", Text: "First I'll read it."},
+		&ClaudeContentBlockToolUse{Type: "tool_use", ID: testToolID(2), Name: "Read", Input: map[string]any{"path": "a.go"}},
+		&ClaudeContentBlockText{Type: "text", Text: "Then list the dir."},
+		&ClaudeContentBlockToolUse{Type: "tool_use", ID: testToolID(3), Name: "LS", Input: map[string]any{}},
+		&ClaudeContentBlockText{Type: "text", Text: "Done."},
+	}
+	if !reflect.DeepEqual(resp.Content, want) {
+		t.Errorf("Content mismatch: got %+v, want %+v", resp.Content, want)
+	}
+}
+
+func TestParseClaudeStreamToResponse_KeepsBlockOrder(t *testing.T) {
+	claudeStream := `event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_1","name":"Bash","input":{}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"command\":\"ls\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: content_block_start
+data: {"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"Listing files."}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":1}
+
+`
+	resp, err := ParseClaudeStreamToResponse(strings.NewReader(claudeStream))
+	if err != nil {
+		t.Fatalf("ParseClaudeStreamToResponse error: %v", err)
+	}
+	want := []any{
+		&ClaudeContentBlockToolUse{Type: "tool_use", ID: "toolu_1", Name: "Bash", Input: map[string]any{"command": "ls"}},
+		&ClaudeContentBlockText{Type: "text", Text: "Listing files."},
+	}
+	if !reflect.DeepEqual(resp.Content, want) {
+		t.Errorf("Content mismatch: got %+v, want %+v", resp.Content, want)
+	}
+}
+
+func TestConvertOAIStreamToClaudeStream_Usage(t *testing.T) {
+	const textChunk = `data: {"id":"cmpl-abc","object":"chat.completion.chunk","model":"gpt-4.1","choices":[{"delta":{"content":"Hi"}}]}` + "\n\n"
+	const finishChunk = `data: {"id":"cmpl-abc","object":"chat.completion.chunk","model":"gpt-4.1","choices":[{"delta":{},"finish_reason":"stop"}]}` + "\n\n"
+	const usageChunk = `data: {"id":"cmpl-abc","object":"chat.completion.chunk","model":"gpt-4.1","choices":[],"usage":{"prompt_tokens":1200,"completion_tokens":42,"total_tokens":1242,"prompt_tokens_details":{"cached_tokens":1024}}}` + "\n\n"
+	tests := []struct {
+		name     string
+		upstream string
+		want     ClaudeUsage
+	}{
+		{
+			name:     "TrailingUsageChunk",
+			upstream: textChunk + finishChunk + usageChunk + "data: [DONE]\n\n",
+			want:     ClaudeUsage{InputTokens: 176, OutputTokens: 42, CacheReadInputTokens: 1024},
+		},
+		{
+			name:     "UsageWithFinishReason",
+			upstream: textChunk + `data: {"id":"cmpl-abc","object":"chat.completion.chunk","model":"gpt-4.1","choices":[{"delta":{},"finish_reason":"stop"}],"usage":{"prompt_tokens":10,"completion_tokens":2,"total_tokens":12}}` + "\n\n",
+			want:     ClaudeUsage{InputTokens: 10, OutputTokens: 2},
+		},
+		{
+			name:     "NoUsage",
+			upstream: textChunk + finishChunk + "data: [DONE]\n\n",
+			want:     ClaudeUsage{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w bytes.Buffer
+			if err := ConvertOAIStreamToClaudeStream(strings.NewReader(tt.upstream), &w, "claude-sonnet-4-20250514"); err != nil {
+				t.Fatalf("ConvertOAIStreamToClaudeStream error: %v", err)
+			}
+			wantDelta, _ := json.Marshal(tt.want)
+			if !strings.Contains(w.String(), `"usage":`+string(wantDelta)) {
+				t.Errorf("Expected usage %s in message_delta, got: %s", wantDelta, w.String())
+			}
+			if strings.Count(w.String(), "event: message_stop") != 1 {
+				t.Errorf("Expected exactly one message_stop, got: %s", w.String())
+			}
+			resp, err := ParseClaudeStreamToResponse(&w)
+			if err != nil {
+				t.Fatalf("ParseClaudeStreamToResponse error: %v", err)
+			}
+			if resp.Usage != tt.want {
+				t.Errorf("Usage mismatch: got %+v, want %+v", resp.Usage, tt.want)
+			}
+			if resp.StopReason == nil || *resp.StopReason != "end_turn" {
+				t.Errorf("Stop reason mismatch: got %v", resp.StopReason)
+			}
+		})
+	}
+}
+
+func TestConvertClaudeToOAI_StreamOptions(t *testing.T) {
+	oaiReq, err := ConvertClaudeToOAI(ClaudeMessagesRequest{
+		Model:     "claude-3-sonnet-20240229",
+		MaxTokens: 10,
+		Messages:  []ClaudeMessage{{Role: "user", Content: "Hi"}},
+	})
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAI error: %v", err)
+	}
+	body, _ := json.Marshal(oaiReq)
+	if !strings.Contains(string(body), `"stream_options":{"include_usage":true}`) {
+		t.Errorf("Expected stream_options to request usage, got: %s", body)
+	}
+}
@@ -0,0 +1,207 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CopilotTokenURL exchanges a GitHub OAuth token for a Copilot session token.
+	CopilotTokenURL = "https://api.github.com/copilot_internal/v2/token"
+	// CopilotAPIURL is the Copilot chat API used when the token response names no endpoint.
+	CopilotAPIURL = "https://api.githubcopilot.com"
+
+	copilotEditorVersion       = "vscode/1.99.3"
+	copilotEditorPluginVersion = "copilot-chat/0.26.7"
+	copilotUserAgent           = "GitHubCopilotChat/0.26.7"
+	copilotIntegrationID       = "vscode-chat"
+)
+
+// Authenticator adds upstream credentials to an outgoing request.
+type Authenticator interface {
+	Authorize(ctx context.Context, req *http.Request) error
+}
+
+// StaticKey authenticates with a fixed bearer token. An empty key sends no Authorization header.
+type StaticKey string
+
+// Authorize sets the bearer token on req.
+func (k StaticKey) Authorize(ctx context.Context, req *http.Request) error {
+	if k != "" {
+		req.Header.Set("Authorization", "Bearer "+string(k))
+	}
+	return nil
+}
+
+// CopilotToken is the response of the Copilot token endpoint.
+type CopilotToken struct {
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"` // unix seconds
+	RefreshIn int64  `json:"refresh_in"` // seconds
+	Endpoints struct {
+		API string `json:"api"`
+	} `json:"endpoints"`
+}
+
+// CopilotAuth exchanges a GitHub OAuth token (ghu_...) for short-lived Copilot session tokens,
+// caches them and refreshes them before they expire. It sends the editor headers Copilot
+// requires on every upstream request.
+type CopilotAuth struct {
+	githubToken string
+	tokenURL    string
+	client      *http.Client
+
+	// RefreshMargin is how long before expiry a token is considered stale.
+	RefreshMargin time.Duration
+	// MinRefreshInterval bounds how often the background loop contacts the token endpoint.
+	MinRefreshInterval time.Duration
+
+	mu        sync.Mutex
+	token     CopilotToken
+	refreshAt time.Time
+	expiresAt time.Time
+	now       func() time.Time
+}
+
+// NewCopilotAuth returns a CopilotAuth for githubToken. An empty tokenURL uses CopilotTokenURL
+// and a nil client uses http.DefaultClient.
+func NewCopilotAuth(githubToken, tokenURL string, client *http.Client) *CopilotAuth {
+	if tokenURL == "" {
+		tokenURL = CopilotTokenURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &CopilotAuth{
+		githubToken:        githubToken,
+		tokenURL:           tokenURL,
+		client:             client,
+		RefreshMargin:      time.Minute,
+		MinRefreshInterval: 5 * time.Second,
+		now:                time.Now,
+	}
+}
+
+// Token returns a valid Copilot session token, exchanging the GitHub token if the cached one
+// is missing or about to expire.
+func (c *CopilotAuth) Token(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token.Token != "" && c.now().Before(c.expiresAt.Add(-c.RefreshMargin)) {
+		return c.token.Token, nil
+	}
+	token, err := c.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.storeLocked(token)
+	return c.token.Token, nil
+}
+
+// APIBase returns the chat API base URL advertised by the last token, or CopilotAPIURL.
+func (c *CopilotAuth) APIBase() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token.Endpoints.API != "" {
+		return strings.TrimRight(c.token.Endpoints.API, "/")
+	}
+	return CopilotAPIURL
+}
+
+// Authorize sets the Copilot session token and editor headers on req.
+func (c *CopilotAuth) Authorize(ctx context.Context, req *http.Request) error {
+	token, err := c.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Editor-Version", copilotEditorVersion)
+	req.Header.Set("Editor-Plugin-Version", copilotEditorPluginVersion)
+	req.Header.Set("Copilot-Integration-Id", copilotIntegrationID)
+	req.Header.Set("User-Agent", copilotUserAgent)
+	req.Header.Set("Openai-Intent", "conversation-panel")
+	return nil
+}
+
+// Start refreshes the token in the background until ctx is cancelled. Failed refreshes are
+// logged and retried; requests keep using the cached token while it is still valid.
+func (c *CopilotAuth) Start(ctx context.Context) {
+	go func() {
+		for {
+			c.mu.Lock()
+			wait := c.refreshAt.Sub(c.now())
+			c.mu.Unlock()
+			if wait < c.MinRefreshInterval {
+				wait = c.MinRefreshInterval
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			token, err := c.fetch(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Warn("Copilot token refresh failed", "error", err)
+				}
+				continue
+			}
+			c.mu.Lock()
+			c.storeLocked(token)
+			c.mu.Unlock()
+		}
+	}()
+}
+
+// fetch exchanges the GitHub token for a new Copilot token.
+func (c *CopilotAuth) fetch(ctx context.Context) (CopilotToken, error) {
+	var token CopilotToken
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.tokenURL, nil)
+	if err != nil {
+		return token, err
+	}
+	req.Header.Set("Authorization", "token "+c.githubToken)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Editor-Version", copilotEditorVersion)
+	req.Header.Set("Editor-Plugin-Version", copilotEditorPluginVersion)
+	req.Header.Set("User-Agent", copilotUserAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return token, fmt.Errorf("copilot token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return token, fmt.Errorf("copilot token exchange: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return token, fmt.Errorf("copilot token exchange: status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return token, fmt.Errorf("copilot token exchange: %w", err)
+	}
+	if token.Token == "" {
+		return token, fmt.Errorf("copilot token exchange: response contained no token")
+	}
+	return token, nil
+}
+
+// storeLocked caches token and schedules its refresh. c.mu must be held.
+func (c *CopilotAuth) storeLocked(token CopilotToken) {
+	now := c.now()
+	c.token = token
+	c.expiresAt = time.Unix(token.ExpiresAt, 0)
+	if token.RefreshIn > 0 {
+		c.refreshAt = now.Add(time.Duration(token.RefreshIn) * time.Second)
+	} else {
+		c.refreshAt = c.expiresAt.Add(-c.RefreshMargin)
+	}
+}
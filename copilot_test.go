@@ -0,0 +1,127 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeCopilotTokenServer issues numbered tokens that expire after ttl.
+func fakeCopilotTokenServer(t *testing.T, ttl time.Duration, apiBase string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var issued atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token ghu_test" {
+			http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Editor-Version") == "" {
+			http.Error(w, `{"message":"missing editor version"}`, http.StatusBadRequest)
+			return
+		}
+		n := issued.Add(1)
+		resp := map[string]any{
+			"token":      fmt.Sprintf("tid=%d", n),
+			"expires_at": time.Now().Add(ttl).Unix(),
+		}
+		if apiBase != "" {
+			resp["endpoints"] = map[string]any{"api": apiBase}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &issued
+}
+
+func TestCopilotAuth_CachesToken(t *testing.T) {
+	srv, issued := fakeCopilotTokenServer(t, time.Hour, "https://api.example.test/")
+	auth := NewCopilotAuth("ghu_test", srv.URL, srv.Client())
+
+	for i := 0; i < 3; i++ {
+		token, err := auth.Token(context.Background())
+		if err != nil {
+			t.Fatalf("Token error: %v", err)
+		}
+		if token != "tid=1" {
+			t.Errorf("Expected cached token tid=1, got %q", token)
+		}
+	}
+	if issued.Load() != 1 {
+		t.Errorf("Expected a single exchange, got %d", issued.Load())
+	}
+	if got := auth.APIBase(); got != "https://api.example.test" {
+		t.Errorf("APIBase mismatch: got %q", got)
+	}
+}
+
+func TestCopilotAuth_RefreshesExpiredToken(t *testing.T) {
+	srv, issued := fakeCopilotTokenServer(t, time.Hour, "")
+	auth := NewCopilotAuth("ghu_test", srv.URL, srv.Client())
+	now := time.Now()
+	auth.now = func() time.Time { return now }
+
+	if token, _ := auth.Token(context.Background()); token != "tid=1" {
+		t.Fatalf("Unexpected first token %q", token)
+	}
+	// Move the clock into the refresh margin.
+	now = now.Add(time.Hour - 30*time.Second)
+	if token, _ := auth.Token(context.Background()); token != "tid=2" {
+		t.Errorf("Expected a refreshed token, got %q", token)
+	}
+	if issued.Load() != 2 {
+		t.Errorf("Expected two exchanges, got %d", issued.Load())
+	}
+	if got := auth.APIBase(); got != CopilotAPIURL {
+		t.Errorf("APIBase should default to %s, got %q", CopilotAPIURL, got)
+	}
+}
+
+func TestCopilotAuth_BackgroundRefresh(t *testing.T) {
+	srv, issued := fakeCopilotTokenServer(t, time.Second, "")
+	auth := NewCopilotAuth("ghu_test", srv.URL, srv.Client())
+	auth.RefreshMargin = time.Second
+	auth.MinRefreshInterval = 10 * time.Millisecond
+
+	if _, err := auth.Token(context.Background()); err != nil {
+		t.Fatalf("Token error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	auth.Start(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for issued.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if issued.Load() < 3 {
+		t.Errorf("Expected the background loop to refresh the token, got %d exchanges", issued.Load())
+	}
+}
+
+func TestCopilotAuth_Authorize(t *testing.T) {
+	srv, _ := fakeCopilotTokenServer(t, time.Hour, "")
+	auth := NewCopilotAuth("ghu_test", srv.URL, srv.Client())
+
+	req := httptest.NewRequest(http.MethodPost, "/chat/completions", nil)
+	if err := auth.Authorize(context.Background(), req); err != nil {
+		t.Fatalf("Authorize error: %v", err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer tid=1" {
+		t.Errorf("Authorization mismatch: got %q", got)
+	}
+	for _, h := range []string{"Editor-Version", "Editor-Plugin-Version", "Copilot-Integration-Id", "User-Agent"} {
+		if req.Header.Get(h) == "" {
+			t.Errorf("Missing header %s", h)
+		}
+	}
+
+	bad := NewCopilotAuth("ghu_wrong", srv.URL, srv.Client())
+	if err := bad.Authorize(context.Background(), req); err == nil {
+		t.Errorf("Expected an error for rejected credentials")
+	}
+}
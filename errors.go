@@ -0,0 +1,175 @@
+package claudecodeproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ClaudeError is the error object of a Claude API error response.
+type ClaudeError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// ClaudeErrorResponse is the body of a Claude API error response, also used as the data of
+// an "error" stream event.
+type ClaudeErrorResponse struct {
+	Type  string      `json:"type"` // always "error"
+	Error ClaudeError `json:"error"`
+}
+
+// APIError is an error reported to Claude clients in the Anthropic error format.
+type APIError struct {
+	Status  int    // HTTP status code
+	Type    string // Anthropic error type, e.g. "rate_limit_error"
+	Message string
+	// RetryAfter is the upstream's Retry-After header, passed on to the client.
+	RetryAfter string
+}
+
+// NewAPIError returns an APIError whose type is derived from the HTTP status.
+func NewAPIError(status int, format string, args ...any) *APIError {
+	return &APIError{
+		Status:  status,
+		Type:    ErrorTypeForStatus(status),
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// NewUpstreamError converts a failed upstream response into an APIError, keeping the
+// upstream status and extracting a readable message from the body.
+func NewUpstreamError(status int, body []byte) *APIError {
+	return &APIError{
+		Status:  status,
+		Type:    ErrorTypeForStatus(status),
+		Message: upstreamErrorMessage(status, body),
+	}
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s (%d): %s", e.Type, e.Status, e.Message)
+}
+
+// Response returns the Claude error body for e.
+func (e *APIError) Response() ClaudeErrorResponse {
+	return ClaudeErrorResponse{
+		Type:  "error",
+		Error: ClaudeError{Type: e.Type, Message: e.Message},
+	}
+}
+
+// OAIErrorResponse is the body of an OpenAI API error response.
+type OAIErrorResponse struct {
+	Error OAIStreamError `json:"error"`
+}
+
+// OAIResponse returns the OpenAI error body for e, for clients of the OpenAI-compatible endpoints.
+func (e *APIError) OAIResponse() OAIErrorResponse {
+	return OAIErrorResponse{Error: OAIStreamError{Message: e.Message, Type: e.Type}}
+}
+
+// ErrorTypeForStatus maps an HTTP status code to an Anthropic error type.
+func ErrorTypeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status == http.StatusServiceUnavailable, status == 529:
+		return "overloaded_error"
+	case status >= 400 && status < 500:
+		return "invalid_request_error"
+	default:
+		return "api_error"
+	}
+}
+
+// statusForErrorType is the inverse of ErrorTypeForStatus, used to restore the HTTP status of
+// an error event read back from a stream.
+func statusForErrorType(errType string) int {
+	switch errType {
+	case "invalid_request_error":
+		return http.StatusBadRequest
+	case "authentication_error":
+		return http.StatusUnauthorized
+	case "permission_error":
+		return http.StatusForbidden
+	case "not_found_error":
+		return http.StatusNotFound
+	case "request_too_large":
+		return http.StatusRequestEntityTooLarge
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case "overloaded_error":
+		return 529
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// upstreamErrorMessage extracts the message from an OpenAI-style error body
+// ({"error":{"message":...}}, {"error":"..."} or {"message":...}), falling back to the raw body.
+func upstreamErrorMessage(status int, body []byte) string {
+	var parsed struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		var obj struct {
+			Message string `json:"message"`
+		}
+		var str string
+		switch {
+		case json.Unmarshal(parsed.Error, &obj) == nil && obj.Message != "":
+			return obj.Message
+		case json.Unmarshal(parsed.Error, &str) == nil && str != "":
+			return str
+		case parsed.Message != "":
+			return parsed.Message
+		}
+	}
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return msg
+	}
+	return fmt.Sprintf("upstream returned %d %s", status, http.StatusText(status))
+}
+
+// WriteError writes err as a Claude error response. Errors that are not APIErrors are
+// reported as internal api_errors.
+func WriteError(w http.ResponseWriter, err error) {
+	apiErr := asAPIError(err)
+	apiErr.writeHeader(w)
+	json.NewEncoder(w).Encode(apiErr.Response())
+}
+
+// WriteOAIError is WriteError for the OpenAI-compatible endpoints.
+func WriteOAIError(w http.ResponseWriter, err error) {
+	apiErr := asAPIError(err)
+	apiErr.writeHeader(w)
+	json.NewEncoder(w).Encode(apiErr.OAIResponse())
+}
+
+func (e *APIError) writeHeader(w http.ResponseWriter) {
+	if e.RetryAfter != "" {
+		w.Header().Set("Retry-After", e.RetryAfter)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+}
+
+func asAPIError(err error) *APIError {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		apiErr = NewAPIError(http.StatusInternalServerError, "%s", err.Error())
+	}
+	return apiErr
+}
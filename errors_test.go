@@ -0,0 +1,84 @@
+package claudecodeproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorTypeForStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		want   string
+	}{
+		{http.StatusBadRequest, "invalid_request_error"},
+		{http.StatusUnauthorized, "authentication_error"},
+		{http.StatusForbidden, "permission_error"},
+		{http.StatusNotFound, "not_found_error"},
+		{http.StatusRequestEntityTooLarge, "request_too_large"},
+		{http.StatusTooManyRequests, "rate_limit_error"},
+		{http.StatusUnprocessableEntity, "invalid_request_error"},
+		{http.StatusInternalServerError, "api_error"},
+		{http.StatusBadGateway, "api_error"},
+		{http.StatusServiceUnavailable, "overloaded_error"},
+		{529, "overloaded_error"},
+	}
+	for _, tt := range tests {
+		if got := ErrorTypeForStatus(tt.status); got != tt.want {
+			t.Errorf("ErrorTypeForStatus(%d) mismatch: got %q, want %q", tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestNewUpstreamErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"OpenAIObject", `{"error":{"message":"Rate limit reached","type":"requests"}}`, "Rate limit reached"},
+		{"ErrorString", `{"error":"bad model"}`, "bad model"},
+		{"TopLevelMessage", `{"message":"quota exceeded"}`, "quota exceeded"},
+		{"PlainText", "upstream exploded\n", "upstream exploded"},
+		{"Empty", "", "upstream returned 502 Bad Gateway"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := http.StatusBadGateway
+			if tt.body != "" {
+				status = http.StatusTooManyRequests
+			}
+			err := NewUpstreamError(status, []byte(tt.body))
+			if err.Message != tt.want {
+				t.Errorf("Message mismatch: got %q, want %q", err.Message, tt.want)
+			}
+			if err.Status != status {
+				t.Errorf("Status mismatch: got %d, want %d", err.Status, status)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteError(rec, NewAPIError(http.StatusTooManyRequests, "slow down"))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Status mismatch: got %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	var body ClaudeErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	want := ClaudeErrorResponse{Type: "error", Error: ClaudeError{Type: "rate_limit_error", Message: "slow down"}}
+	if body != want {
+		t.Errorf("Body mismatch: got %+v, want %+v", body, want)
+	}
+
+	rec = httptest.NewRecorder()
+	WriteError(rec, errors.New("boom"))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Status mismatch for plain error: got %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
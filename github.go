@@ -0,0 +1,155 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// GitHubDeviceCodeURL starts the OAuth device flow.
+	GitHubDeviceCodeURL = "https://github.com/login/device/code"
+	// GitHubAccessTokenURL is polled for the access token during the device flow.
+	GitHubAccessTokenURL = "https://github.com/login/oauth/access_token"
+	// CopilotClientID is the OAuth app used by the Copilot editor integrations.
+	CopilotClientID = "Iv1.b507a08c87ecfe98"
+)
+
+// DeviceCode is the response of the device code endpoint.
+type DeviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"` // seconds
+	Interval        int    `json:"interval"`   // seconds
+}
+
+// DeviceFlow implements the GitHub OAuth device authorization flow.
+type DeviceFlow struct {
+	ClientID       string
+	Scope          string
+	DeviceCodeURL  string
+	AccessTokenURL string
+	Client         *http.Client
+
+	after func(time.Duration) <-chan time.Time
+}
+
+// NewDeviceFlow returns a DeviceFlow for the Copilot OAuth app against github.com.
+func NewDeviceFlow() *DeviceFlow {
+	return &DeviceFlow{
+		ClientID:       CopilotClientID,
+		Scope:          "read:user",
+		DeviceCodeURL:  GitHubDeviceCodeURL,
+		AccessTokenURL: GitHubAccessTokenURL,
+		Client:         http.DefaultClient,
+	}
+}
+
+// RequestCode starts the flow and returns the code the user has to enter.
+func (f *DeviceFlow) RequestCode(ctx context.Context) (DeviceCode, error) {
+	var code DeviceCode
+	err := f.post(ctx, f.DeviceCodeURL, url.Values{
+		"client_id": {f.ClientID},
+		"scope":     {f.Scope},
+	}, &code)
+	if err != nil {
+		return code, fmt.Errorf("request device code: %w", err)
+	}
+	if code.DeviceCode == "" || code.UserCode == "" {
+		return code, fmt.Errorf("request device code: incomplete response")
+	}
+	return code, nil
+}
+
+// PollToken polls until the user authorizes the device, the code expires or ctx is done,
+// and returns the OAuth access token.
+func (f *DeviceFlow) PollToken(ctx context.Context, code DeviceCode) (string, error) {
+	after := f.after
+	if after == nil {
+		after = time.After
+	}
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	var expired <-chan time.Time
+	if code.ExpiresIn > 0 {
+		expired = time.After(time.Duration(code.ExpiresIn) * time.Second)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-expired:
+			return "", fmt.Errorf("device code expired before authorization")
+		case <-after(interval):
+		}
+
+		var resp struct {
+			AccessToken      string `json:"access_token"`
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+			Interval         int    `json:"interval"`
+		}
+		err := f.post(ctx, f.AccessTokenURL, url.Values{
+			"client_id":   {f.ClientID},
+			"device_code": {code.DeviceCode},
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &resp)
+		if err != nil {
+			return "", fmt.Errorf("poll access token: %w", err)
+		}
+		switch resp.Error {
+		case "":
+			if resp.AccessToken == "" {
+				return "", fmt.Errorf("poll access token: response contained no token")
+			}
+			return resp.AccessToken, nil
+		case "authorization_pending":
+		case "slow_down":
+			if resp.Interval > 0 {
+				interval = time.Duration(resp.Interval) * time.Second
+			} else {
+				interval += 5 * time.Second
+			}
+		default:
+			if resp.ErrorDescription != "" {
+				return "", fmt.Errorf("device authorization failed: %s: %s", resp.Error, resp.ErrorDescription)
+			}
+			return "", fmt.Errorf("device authorization failed: %s", resp.Error)
+		}
+	}
+}
+
+func (f *DeviceFlow) post(ctx context.Context, endpoint string, form url.Values, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, out)
+}
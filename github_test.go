@@ -0,0 +1,108 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGitHubOAuth serves the device flow endpoints, answering the token endpoint with
+// the given responses in order.
+func fakeGitHubOAuth(t *testing.T, pollResponses ...map[string]any) (*httptest.Server, *int) {
+	t.Helper()
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/device/code", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_id") != "test-client" {
+			http.Error(w, "bad client", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"device_code":      "dev-123",
+			"user_code":        "ABCD-1234",
+			"verification_uri": "https://github.com/login/device",
+			"expires_in":       900,
+			"interval":         5,
+		})
+	})
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("device_code") != "dev-123" || !strings.HasSuffix(r.Form.Get("grant_type"), "device_code") {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		resp := pollResponses[polls]
+		polls++
+		json.NewEncoder(w).Encode(resp)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, &polls
+}
+
+func testDeviceFlow(srv *httptest.Server) (*DeviceFlow, *[]time.Duration) {
+	var waits []time.Duration
+	flow := NewDeviceFlow()
+	flow.ClientID = "test-client"
+	flow.DeviceCodeURL = srv.URL + "/login/device/code"
+	flow.AccessTokenURL = srv.URL + "/login/oauth/access_token"
+	flow.Client = srv.Client()
+	flow.after = func(d time.Duration) <-chan time.Time {
+		waits = append(waits, d)
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+	return flow, &waits
+}
+
+func TestDeviceFlow_Success(t *testing.T) {
+	srv, polls := fakeGitHubOAuth(t,
+		map[string]any{"error": "authorization_pending"},
+		map[string]any{"error": "slow_down"},
+		map[string]any{"access_token": "ghu_fromdevice", "token_type": "bearer"},
+	)
+	flow, waits := testDeviceFlow(srv)
+
+	code, err := flow.RequestCode(context.Background())
+	if err != nil {
+		t.Fatalf("RequestCode error: %v", err)
+	}
+	if code.UserCode != "ABCD-1234" || code.VerificationURI == "" {
+		t.Errorf("Unexpected device code: %+v", code)
+	}
+	token, err := flow.PollToken(context.Background(), code)
+	if err != nil {
+		t.Fatalf("PollToken error: %v", err)
+	}
+	if token != "ghu_fromdevice" {
+		t.Errorf("Token mismatch: got %q", token)
+	}
+	if *polls != 3 {
+		t.Errorf("Expected 3 polls, got %d", *polls)
+	}
+	want := []time.Duration{5 * time.Second, 5 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if (*waits)[i] != w {
+			t.Errorf("Wait %d: got %v, want %v", i, (*waits)[i], w)
+		}
+	}
+}
+
+func TestDeviceFlow_Denied(t *testing.T) {
+	srv, _ := fakeGitHubOAuth(t, map[string]any{"error": "access_denied", "error_description": "The user denied the request"})
+	flow, _ := testDeviceFlow(srv)
+
+	code, err := flow.RequestCode(context.Background())
+	if err != nil {
+		t.Fatalf("RequestCode error: %v", err)
+	}
+	if _, err := flow.PollToken(context.Background(), code); err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Errorf("Expected access_denied error, got %v", err)
+	}
+}
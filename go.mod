@@ -1,3 +1,5 @@
 module claude-proxy
 
 go 1.24.3
+
+require golang.org/x/image v0.25.0
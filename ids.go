@@ -0,0 +1,98 @@
+package claudecodeproxy
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+)
+
+// ID prefixes of generated Claude objects.
+const (
+	MessageIDPrefix = "msg_"
+	ToolUseIDPrefix = "toolu_"
+)
+
+// IDGenerator makes the IDs of messages and tool_use blocks the proxy generates.
+type IDGenerator interface {
+	// NewID returns an ID starting with prefix that has not been returned before.
+	NewID(prefix string) string
+}
+
+// RandomIDs generates random IDs shaped like Anthropic's, e.g. "msg_01" followed by 22
+// base62 characters.
+type RandomIDs struct{}
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// NewID implements IDGenerator.
+func (RandomIDs) NewID(prefix string) string {
+	b := make([]byte, 22)
+	rand.Read(b)
+	for i := range b {
+		// The slight modulo bias does not matter for IDs.
+		b[i] = base62[int(b[i])%len(base62)]
+	}
+	return prefix + "01" + string(b)
+}
+
+// SequentialIDs generates predictable IDs for tests: the prefix followed by a 24-digit
+// counter shared by all prefixes, starting at 1. The zero value is ready to use.
+type SequentialIDs struct {
+	mu sync.Mutex
+	n  int
+}
+
+// NewID implements IDGenerator.
+func (g *SequentialIDs) NewID(prefix string) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.n++
+	return fmt.Sprintf("%s%024d", prefix, g.n)
+}
+
+// ToolIDMap remembers the upstream tool call ID behind each generated Claude tool ID, so that
+// requests continuing a conversation send the upstream its own IDs back. It is safe for
+// concurrent use, and its methods do nothing on nil.
+type ToolIDMap struct {
+	// MaxEntries bounds the map, forgetting the oldest IDs first; zero keeps every ID.
+	MaxEntries int
+
+	mu       sync.Mutex
+	upstream map[string]string
+	order    []string
+}
+
+// NewToolIDMap returns an empty ToolIDMap holding at most maxEntries IDs.
+func NewToolIDMap(maxEntries int) *ToolIDMap {
+	return &ToolIDMap{MaxEntries: maxEntries, upstream: map[string]string{}}
+}
+
+// Add records that the Claude tool ID claudeID stands for the upstream ID upstreamID.
+func (m *ToolIDMap) Add(claudeID, upstreamID string) {
+	if m == nil || upstreamID == "" {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.upstream[claudeID]; !ok {
+		m.order = append(m.order, claudeID)
+	}
+	m.upstream[claudeID] = upstreamID
+	if m.MaxEntries > 0 && len(m.order) > m.MaxEntries {
+		delete(m.upstream, m.order[0])
+		m.order = m.order[1:]
+	}
+}
+
+// UpstreamID returns the upstream ID behind claudeID, or claudeID itself if it is unknown.
+func (m *ToolIDMap) UpstreamID(claudeID string) string {
+	if m == nil {
+		return claudeID
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id, ok := m.upstream[claudeID]; ok {
+		return id
+	}
+	return claudeID
+}
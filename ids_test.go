@@ -0,0 +1,118 @@
+package claudecodeproxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// testToolID returns the n-th ID of a SequentialIDs as a tool_use ID.
+func testToolID(n int) string {
+	return fmt.Sprintf("%s%024d", ToolUseIDPrefix, n)
+}
+
+func TestRandomIDs(t *testing.T) {
+	shape := regexp.MustCompile(`^msg_01[0-9A-Za-z]{22}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := RandomIDs{}.NewID(MessageIDPrefix)
+		if !shape.MatchString(id) {
+			t.Fatalf("ID shape mismatch: got %q", id)
+		}
+		if seen[id] {
+			t.Fatalf("Duplicate ID %q", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestSequentialIDs(t *testing.T) {
+	var ids SequentialIDs
+	got := []string{ids.NewID(MessageIDPrefix), ids.NewID(ToolUseIDPrefix), ids.NewID(ToolUseIDPrefix)}
+	want := []string{"msg_000000000000000000000001", testToolID(2), testToolID(3)}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ID %d mismatch: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestToolIDMap(t *testing.T) {
+	m := NewToolIDMap(2)
+	m.Add("toolu_a", "call_a")
+	m.Add("toolu_b", "")
+	m.Add("toolu_c", "call_c")
+	if got := m.UpstreamID("toolu_a"); got != "call_a" {
+		t.Errorf("UpstreamID mismatch: got %q, want call_a", got)
+	}
+	// Calls without an upstream ID keep their Claude ID.
+	if got := m.UpstreamID("toolu_b"); got != "toolu_b" {
+		t.Errorf("UpstreamID mismatch: got %q, want toolu_b", got)
+	}
+	m.Add("toolu_d", "call_d")
+	if got := m.UpstreamID("toolu_a"); got != "toolu_a" {
+		t.Errorf("Expected the oldest ID to be forgotten, got %q", got)
+	}
+	if got := m.UpstreamID("toolu_d"); got != "call_d" {
+		t.Errorf("UpstreamID mismatch: got %q, want call_d", got)
+	}
+
+	var nilMap *ToolIDMap
+	nilMap.Add("toolu_a", "call_a")
+	if got := nilMap.UpstreamID("toolu_a"); got != "toolu_a" {
+		t.Errorf("Expected a nil map to keep IDs, got %q", got)
+	}
+}
+
+func TestConvert_ToolIDRoundTrip(t *testing.T) {
+	opts := ConvertOptions{IDs: &SequentialIDs{}, ToolIDs: NewToolIDMap(0)}
+	upstream := oaiChunks("tool_calls",
+		`{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"Read","arguments":"{}"}}]}`,
+		`{"tool_calls":[{"index":1,"id":"","function":{"name":"LS","arguments":"{}"}}]}`,
+	)
+	var w bytes.Buffer
+	if err := ConvertOAIStreamToClaudeStreamWithOptions(context.Background(), strings.NewReader(upstream), &w, "claude-sonnet-4-20250514", opts); err != nil {
+		t.Fatalf("ConvertOAIStreamToClaudeStreamWithOptions error: %v", err)
+	}
+	resp, err := ParseClaudeStreamToResponse(&w)
+	if err != nil {
+		t.Fatalf("ParseClaudeStreamToResponse error: %v", err)
+	}
+	if resp.ID != "msg_000000000000000000000001" {
+		t.Errorf("Message ID mismatch: got %q", resp.ID)
+	}
+	var toolUses []any
+	var results []any
+	for _, block := range resp.Content {
+		tu := block.(*ClaudeContentBlockToolUse)
+		toolUses = append(toolUses, *tu)
+		results = append(results, ClaudeContentBlockToolResult{Type: "tool_result", ToolUseID: tu.ID, Content: "ok"})
+	}
+	if len(toolUses) != 2 || toolUses[0].(ClaudeContentBlockToolUse).ID != testToolID(2) || toolUses[1].(ClaudeContentBlockToolUse).ID != testToolID(3) {
+		t.Fatalf("tool_use blocks mismatch: got %+v", toolUses)
+	}
+
+	// The next request sends the upstream its own ID back, and the generated one where it sent none.
+	oaiReq, err := ConvertClaudeToOAIWithOptions(ClaudeMessagesRequest{
+		Model:     "claude-sonnet-4-20250514",
+		MaxTokens: 64,
+		Messages: []ClaudeMessage{
+			{Role: "user", Content: "List and read."},
+			{Role: "assistant", Content: toolUses},
+			{Role: "user", Content: results},
+		},
+	}, opts)
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAIWithOptions error: %v", err)
+	}
+	calls := oaiReq.Messages[1].ToolCalls
+	if len(calls) != 2 || calls[0].ID != "call_1" || calls[1].ID != testToolID(3) {
+		t.Errorf("Tool call IDs mismatch: got %+v", calls)
+	}
+	if got := []string{oaiReq.Messages[2].ToolCallID, oaiReq.Messages[3].ToolCallID}; got[0] != "call_1" || got[1] != testToolID(3) {
+		t.Errorf("Tool result IDs mismatch: got %q", got)
+	}
+}
@@ -0,0 +1,151 @@
+package claudecodeproxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // register decoder
+	"image/jpeg"
+	_ "image/png" // register decoder
+	"math"
+	"strings"
+
+	_ "golang.org/x/image/webp" // register decoder
+)
+
+// Image handling modes for images larger than ImageOptions.MaxBytes.
+const (
+	ImageModeReject    = "reject"
+	ImageModeDownscale = "downscale"
+)
+
+// ImageOptions controls how image content blocks are forwarded upstream.
+type ImageOptions struct {
+	// MaxBytes is the largest decoded base64 image forwarded as is. Zero means no limit.
+	MaxBytes int
+	// Mode is ImageModeReject (the default) or ImageModeDownscale for images above MaxBytes.
+	Mode string
+}
+
+// convertImageClaudeToOAI converts a Claude image block source into an OAI image_url part.
+func convertImageClaudeToOAI(source map[string]any, opts ImageOptions) (OAIMessageContent, error) {
+	sourceType, _ := source["type"].(string)
+	switch sourceType {
+	case "url":
+		u, _ := source["url"].(string)
+		if u == "" {
+			return OAIMessageContent{}, fmt.Errorf("image url source has no url")
+		}
+		return OAIMessageContent{Type: "image_url", ImageURL: &OAIImageURL{URL: u}}, nil
+	case "base64":
+		mediaType, _ := source["media_type"].(string)
+		data, _ := source["data"].(string)
+		if mediaType == "" || data == "" {
+			return OAIMessageContent{}, fmt.Errorf("base64 image source needs media_type and data")
+		}
+		if opts.MaxBytes > 0 && base64.StdEncoding.DecodedLen(len(data)) > opts.MaxBytes {
+			raw, err := base64.StdEncoding.DecodeString(data)
+			if err != nil {
+				return OAIMessageContent{}, fmt.Errorf("decode image: %w", err)
+			}
+			if len(raw) > opts.MaxBytes {
+				if opts.Mode != ImageModeDownscale {
+					return OAIMessageContent{}, fmt.Errorf("image is %d bytes, larger than the %d byte limit", len(raw), opts.MaxBytes)
+				}
+				scaled, err := downscaleImage(raw, opts.MaxBytes)
+				if err != nil {
+					return OAIMessageContent{}, fmt.Errorf("downscale image: %w", err)
+				}
+				mediaType = "image/jpeg"
+				data = base64.StdEncoding.EncodeToString(scaled)
+			}
+		}
+		return OAIMessageContent{
+			Type:     "image_url",
+			ImageURL: &OAIImageURL{URL: "data:" + mediaType + ";base64," + data},
+		}, nil
+	}
+	return OAIMessageContent{}, fmt.Errorf("unsupported image source type %q", sourceType)
+}
+
+// imageSourceFromURL converts an OAI image URL to a Claude image source: data URLs become
+// base64 sources, anything else a url source.
+func imageSourceFromURL(u string) map[string]any {
+	if rest, ok := strings.CutPrefix(u, "data:"); ok {
+		if meta, data, ok := strings.Cut(rest, ","); ok {
+			if mediaType, ok := strings.CutSuffix(meta, ";base64"); ok {
+				return map[string]any{"type": "base64", "media_type": mediaType, "data": data}
+			}
+		}
+	}
+	return map[string]any{"type": "url", "url": u}
+}
+
+// downscaleImage re-encodes an image as JPEG, shrinking it until it fits in maxBytes.
+func downscaleImage(raw []byte, maxBytes int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	size := len(raw)
+	img := src
+	for attempt := 0; attempt < 8; attempt++ {
+		// Encoded size scales roughly with the pixel count, so shrink both sides by the
+		// square root of the ratio, with some headroom.
+		scale := math.Sqrt(float64(maxBytes)/float64(size)) * 0.9
+		if scale > 1 {
+			scale = 1
+		}
+		b := img.Bounds()
+		w := max(1, int(float64(b.Dx())*scale))
+		h := max(1, int(float64(b.Dy())*scale))
+		img = resizeImage(src, w, h)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		if buf.Len() <= maxBytes {
+			return buf.Bytes(), nil
+		}
+		size = buf.Len()
+		if w == 1 && h == 1 {
+			break
+		}
+	}
+	return nil, fmt.Errorf("could not shrink image below %d bytes", maxBytes)
+}
+
+// resizeImage scales src to w×h by averaging the source pixels covered by each target pixel.
+// Transparent areas are composited onto white, since the result is encoded as JPEG.
+func resizeImage(src image.Image, w, h int) *image.RGBA {
+	sb := src.Bounds()
+	flat := image.NewRGBA(sb)
+	draw.Draw(flat, sb, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, sb, src, sb.Min, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := sb.Min.Y + y*sb.Dy()/h
+		y1 := max(y0+1, sb.Min.Y+(y+1)*sb.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := sb.Min.X + x*sb.Dx()/w
+			x1 := max(x0+1, sb.Min.X+(x+1)*sb.Dx()/w)
+			var r, g, bl, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := flat.RGBAAt(sx, sy)
+					r += uint32(c.R)
+					g += uint32(c.G)
+					bl += uint32(c.B)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), 255})
+		}
+	}
+	return dst
+}
@@ -0,0 +1,168 @@
+package claudecodeproxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// noisyPNG returns a PNG that compresses poorly, so it is large for its dimensions.
+func noisyPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetRGBA(x, y, color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func imageRequest(source map[string]any) ClaudeMessagesRequest {
+	return ClaudeMessagesRequest{
+		Model:     "claude-3-sonnet-20240229",
+		MaxTokens: 256,
+		Messages: []ClaudeMessage{{Role: "user", Content: []any{
+			map[string]any{"type": "text", "text": "What is this?"},
+			map[string]any{"type": "image", "source": source},
+		}}},
+	}
+}
+
+func TestConvertClaudeToOAI_Images(t *testing.T) {
+	oaiReq, err := ConvertClaudeToOAI(imageRequest(map[string]any{
+		"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo=",
+	}))
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAI error: %v", err)
+	}
+	parts := oaiReq.Messages[0].Content
+	if len(parts) != 2 || parts[1].Type != "image_url" || parts[1].ImageURL.URL != "data:image/png;base64,iVBORw0KGgo=" {
+		t.Fatalf("Image part mismatch: %+v", parts)
+	}
+	body, _ := json.Marshal(parts)
+	want := `[{"type":"text","text":"What is this?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBORw0KGgo="}}]`
+	if string(body) != want {
+		t.Errorf("JSON mismatch:\ngot  %s\nwant %s", body, want)
+	}
+
+	oaiReq, err = ConvertClaudeToOAI(imageRequest(map[string]any{"type": "url", "url": "https://example.com/cat.png"}))
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAI error: %v", err)
+	}
+	if got := oaiReq.Messages[0].Content[1].ImageURL.URL; got != "https://example.com/cat.png" {
+		t.Errorf("URL source should pass through, got %q", got)
+	}
+}
+
+func TestConvertClaudeToOAI_ImageFromToolResult(t *testing.T) {
+	claudeReq := ClaudeMessagesRequest{
+		Model: "claude-3-sonnet-20240229",
+		Messages: []ClaudeMessage{
+			{Role: "assistant", Content: []any{map[string]any{"type": "tool_use", "id": "toolu_1", "name": "Screenshot", "input": map[string]any{}}}},
+			{Role: "user", Content: []any{map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": []any{
+				map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}},
+			}}}},
+		},
+	}
+	oaiReq, err := ConvertClaudeToOAI(claudeReq)
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAI error: %v", err)
+	}
+	if len(oaiReq.Messages) != 3 {
+		t.Fatalf("Expected assistant, tool and user messages, got %+v", oaiReq.Messages)
+	}
+	tool := oaiReq.Messages[1]
+	if tool.Role != "tool" || strings.Contains(tool.Content[0].Text, "iVBOR") {
+		t.Errorf("Tool message should not inline the image: %+v", tool)
+	}
+	user := oaiReq.Messages[2]
+	if user.Role != "user" || len(user.Content) != 1 || user.Content[0].Type != "image_url" {
+		t.Errorf("Expected the image in a following user message, got %+v", user)
+	}
+}
+
+func TestConvertClaudeToOAI_ImageSizeLimit(t *testing.T) {
+	raw := noisyPNG(t, 200, 200)
+	source := map[string]any{"type": "base64", "media_type": "image/png", "data": base64.StdEncoding.EncodeToString(raw)}
+	limit := len(raw) / 4
+
+	_, err := ConvertClaudeToOAIWithOptions(imageRequest(source), ConvertOptions{Images: ImageOptions{MaxBytes: limit}})
+	if err == nil || !strings.Contains(err.Error(), "limit") {
+		t.Errorf("Expected oversized image to be rejected, got %v", err)
+	}
+
+	oaiReq, err := ConvertClaudeToOAIWithOptions(imageRequest(source), ConvertOptions{Images: ImageOptions{MaxBytes: limit, Mode: ImageModeDownscale}})
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAIWithOptions error: %v", err)
+	}
+	url := oaiReq.Messages[0].Content[1].ImageURL.URL
+	data, ok := strings.CutPrefix(url, "data:image/jpeg;base64,")
+	if !ok {
+		t.Fatalf("Expected a downscaled JPEG data URL, got %.40s", url)
+	}
+	scaled, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatalf("DecodeString error: %v", err)
+	}
+	if len(scaled) > limit {
+		t.Errorf("Downscaled image is %d bytes, above the %d byte limit", len(scaled), limit)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(scaled))
+	if err != nil {
+		t.Fatalf("Downscaled image does not decode: %v", err)
+	}
+	if img.Bounds().Dx() >= 200 {
+		t.Errorf("Expected smaller dimensions, got %v", img.Bounds())
+	}
+
+	// Images under the limit are forwarded untouched.
+	oaiReq, err = ConvertClaudeToOAIWithOptions(imageRequest(source), ConvertOptions{Images: ImageOptions{MaxBytes: len(raw)}})
+	if err != nil || !strings.HasPrefix(oaiReq.Messages[0].Content[1].ImageURL.URL, "data:image/png;base64,") {
+		t.Errorf("Expected the original image, got err %v", err)
+	}
+}
+
+// gopherWebP is a small lossless WebP, gopher-doc.2bpp.lossless.webp from golang.org/x/image.
+const gopherWebP = "UklGRvwCAABXRUJQVlA4TO8CAAAvSsAYAB8gEEiS3J9li6maqvlPbEPy9n+G5E5qNnZO1tyjucbrjZ1lbKevcW9s2zb6FKPi" +
+	"XG3bHnTPbtX3eap+Vd1JIvrvQJLUuGk7F8GYoNiPQIAtlVY6HAAr9PxFsnIF81NhePmeRdyMpQmnV3nXrMFdYFxKT2GUo7Lr" +
+	"91xrYmcLctDYtQb9XpEWVq4d6+lq0RO9Grc3V1CW6nPswc+3UZR7k8qTbR5OHs67BVarmGC9ZIVPgDMTwnqpsOx38kvVe5lP" +
+	"rDYJ22oy71XK/JXjVoA89RKwyY9e3KUCBwl/yfsUJiPsF75pGFHeXBCWa8AuURf65uqcBwA7dKjfhTSLU07uLTjla4Wyar2E" +
+	"S63DbVTYi3Hl67rwCFn5db81hwTCbc9z+jQIWLE2JbQCkTGRCzQ8Xy5dkz2owCOnLZsqhvWji4AdTY3ChuYFLssObttRDNJ9" +
+	"gFrhLlIH6jV+ISY7CF+Iog0NlyDKSmUH9vl3gEvZpTYfJMDOy6X8QNVybVHxJ9g0ueBPiH49X1oi8xZYq3LAICL89kYEZW+C" +
+	"tUyTJQSRnh4RVV+CTSqWuLK5QaiFcATsc6NlsVLlCZx2nBQ6KGQpik1W80gVe6WYshw4HYWq/k6Kk3USzLslLTaxQ/W4GvNr" +
+	"vEuSsaUCvdIQdmG5RnE5sV6H0oF90hciIU9mhd4C6np4QjzQLlKjqrpxMV0P7YF+v4AXxHbdoBKNPM64badPZg7hBPHjH/FJ" +
+	"GRTkyQqSYmv+wxNlBTHIh1D6BTFVAMkSH2clnvTDwXy1Vjwf6xWuYVwpkWm+1F/yuPDzQWYZBuIRX8wzihE+yxkP4g1hmd2h" +
+	"N1xhdIPeskqNOnipqtIvo/nviDtGH14QXYxW3yfCxsTeNGy7hgejgwSLGqRyyppnMAjU9pqe7obmyfrbuteaD68trmW6mriH" +
+	"lVREaNZzDHpLrIPGkfEKn4D9RUkNrZmXyzPewQwo4nH4YjzhD4F4QSQChLHZC8BCAPgbAA=="
+
+func TestConvertClaudeToOAI_DownscaleWebP(t *testing.T) {
+	raw, _ := base64.StdEncoding.DecodeString(gopherWebP)
+	source := map[string]any{"type": "base64", "media_type": "image/webp", "data": gopherWebP}
+	limit := len(raw) - 1
+
+	oaiReq, err := ConvertClaudeToOAIWithOptions(imageRequest(source), ConvertOptions{Images: ImageOptions{MaxBytes: limit, Mode: ImageModeDownscale}})
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAIWithOptions error: %v", err)
+	}
+	data, ok := strings.CutPrefix(oaiReq.Messages[0].Content[1].ImageURL.URL, "data:image/jpeg;base64,")
+	if !ok {
+		t.Fatalf("Expected a downscaled JPEG data URL, got %.40s", oaiReq.Messages[0].Content[1].ImageURL.URL)
+	}
+	scaled, _ := base64.StdEncoding.DecodeString(data)
+	if len(scaled) > limit {
+		t.Errorf("Downscaled image is %d bytes, above the %d byte limit", len(scaled), limit)
+	}
+}
@@ -0,0 +1,86 @@
+package claudecodeproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, "warn", "json")
+	if err != nil {
+		t.Fatalf("NewLogger error: %v", err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "key", "value")
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a single JSON entry, got %q: %v", buf.String(), err)
+	}
+	if entry["msg"] != "shown" || entry["key"] != "value" {
+		t.Errorf("Entry mismatch: got %v", entry)
+	}
+
+	for _, tt := range []struct{ level, format string }{{"loud", "text"}, {"info", "xml"}} {
+		if _, err := NewLogger(&buf, tt.level, tt.format); err == nil {
+			t.Errorf("Expected an error for level %q, format %q", tt.level, tt.format)
+		}
+	}
+}
+
+func TestLoggerFrom(t *testing.T) {
+	if LoggerFrom(context.Background()) != slog.Default() {
+		t.Error("Expected the default logger without a logger in the context")
+	}
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	if LoggerFrom(WithLogger(context.Background(), logger)) != logger {
+		t.Error("Expected the context's logger")
+	}
+	if id := NewRequestID(); !strings.HasPrefix(id, "req_") || len(id) != 28 || id == NewRequestID() {
+		t.Errorf("Unexpected request ID %q", id)
+	}
+}
+
+func TestConvertClaudeToOAI_LogsDroppedBlocks(t *testing.T) {
+	var logs bytes.Buffer
+	req := ClaudeMessagesRequest{
+		Model:     "claude-3-haiku",
+		MaxTokens: 64,
+		Messages: []ClaudeMessage{{Role: "user", Content: []any{
+			map[string]any{"type": "text", "text": "Summarize"},
+			map[string]any{"type": "document", "source": map[string]any{"type": "text", "data": "..."}},
+		}}},
+	}
+	oaiReq, err := ConvertClaudeToOAIWithOptions(req, ConvertOptions{Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAIWithOptions error: %v", err)
+	}
+	if len(oaiReq.Messages) != 1 || len(oaiReq.Messages[0].Content) != 1 {
+		t.Errorf("Expected only the text part, got %+v", oaiReq.Messages)
+	}
+	if !strings.Contains(logs.String(), "Dropping unsupported content block") || !strings.Contains(logs.String(), "type=document") {
+		t.Errorf("Expected a warning for the document block, got %q", logs.String())
+	}
+}
+
+func TestConvertOAIStreamToClaudeStream_LogsInvalidChunks(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil)).With("request_id", "req_test")
+	upstream := "data: {not json}\n\n" + oaiChunks("stop", `{"content":"Hello"}`)
+
+	var out bytes.Buffer
+	err := ConvertOAIStreamToClaudeStreamContext(WithLogger(context.Background(), logger), strings.NewReader(upstream), &out, "claude-3-haiku")
+	if err != nil {
+		t.Fatalf("Convert error: %v", err)
+	}
+	if strings.Contains(out.String(), "error") {
+		t.Errorf("Warnings must not reach the client stream: %s", out.String())
+	}
+	if !strings.Contains(logs.String(), "Skipping invalid upstream chunk") || !strings.Contains(logs.String(), "request_id=req_test") {
+		t.Errorf("Expected a warning with the request ID, got %q", logs.String())
+	}
+}
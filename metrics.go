@@ -0,0 +1,290 @@
+package claudecodeproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the histogram buckets, in seconds, for upstream latency and time to first token.
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Metrics collects proxy metrics and serves them in the Prometheus text format.
+type Metrics struct {
+	// Requests counts handled requests by Claude model, upstream model and status code. Model
+	// labels should come from a bounded set, such as the routing table.
+	Requests *CounterVec
+	// Responses counts successful responses by mode: "streamed" or "buffered".
+	Responses *CounterVec
+	// UpstreamLatency is the time until the upstream answered, by upstream model.
+	UpstreamLatency *HistogramVec
+	// TimeToFirstToken is the time until the first content reached the client, by upstream model.
+	TimeToFirstToken *HistogramVec
+	// InputTokens, OutputTokens and ToolCalls are taken from the responses, by upstream model.
+	InputTokens  *CounterVec
+	OutputTokens *CounterVec
+	ToolCalls    *CounterVec
+	// Retries counts retried upstream requests and Cancellations requests the client gave up on.
+	Retries       *CounterVec
+	Cancellations *CounterVec
+}
+
+// NewMetrics returns an empty set of proxy metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Requests:         NewCounterVec("claude_proxy_requests_total", "Requests by Claude model, upstream model and status.", "claude_model", "upstream_model", "status"),
+		Responses:        NewCounterVec("claude_proxy_responses_total", "Successful responses by mode.", "mode"),
+		UpstreamLatency:  NewHistogramVec("claude_proxy_upstream_latency_seconds", "Time until the upstream response started.", latencyBuckets, "upstream_model"),
+		TimeToFirstToken: NewHistogramVec("claude_proxy_time_to_first_token_seconds", "Time until the first content was sent to the client.", latencyBuckets, "upstream_model"),
+		InputTokens:      NewCounterVec("claude_proxy_input_tokens_total", "Input tokens reported by the upstream, including cached ones.", "upstream_model"),
+		OutputTokens:     NewCounterVec("claude_proxy_output_tokens_total", "Output tokens reported by the upstream.", "upstream_model"),
+		ToolCalls:        NewCounterVec("claude_proxy_tool_calls_total", "tool_use blocks returned to clients.", "upstream_model"),
+		Retries:          NewCounterVec("claude_proxy_upstream_retries_total", "Upstream requests retried after a failure."),
+		Cancellations:    NewCounterVec("claude_proxy_cancellations_total", "Requests cancelled by the client before completion."),
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	m.Requests.write(bw)
+	m.Responses.write(bw)
+	m.UpstreamLatency.write(bw)
+	m.TimeToFirstToken.write(bw)
+	m.InputTokens.write(bw)
+	m.OutputTokens.write(bw)
+	m.ToolCalls.write(bw)
+	m.Retries.write(bw)
+	m.Cancellations.write(bw)
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ServeHTTP serves the metrics for Prometheus to scrape.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// ObserveEvents returns r wrapped so that the Claude events read from it are counted: the time
+// from start to the first content delta, tool_use blocks and the usage of message_delta.
+func (m *Metrics) ObserveEvents(r io.ReadCloser, start time.Time, upstreamModel string) io.ReadCloser {
+	first := true
+	return WatchSSE(r, func(ev SSEEvent) {
+		switch ev.Event {
+		case "content_block_start":
+			var data struct {
+				ContentBlock struct {
+					Type string `json:"type"`
+				} `json:"content_block"`
+			}
+			if json.Unmarshal([]byte(ev.Data), &data) == nil && data.ContentBlock.Type == "tool_use" {
+				m.ToolCalls.Inc(upstreamModel)
+			}
+		case "content_block_delta":
+			if first {
+				first = false
+				m.TimeToFirstToken.Observe(time.Since(start).Seconds(), upstreamModel)
+			}
+		case "message_delta":
+			var data struct {
+				Usage ClaudeUsage `json:"usage"`
+			}
+			if json.Unmarshal([]byte(ev.Data), &data) == nil {
+				u := data.Usage
+				m.InputTokens.Add(float64(u.InputTokens+u.CacheReadInputTokens+u.CacheCreationInputTokens), upstreamModel)
+				m.OutputTokens.Add(float64(u.OutputTokens), upstreamModel)
+			}
+		}
+	})
+}
+
+// CounterVec is a Prometheus counter with labels.
+type CounterVec struct {
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*counterValue
+}
+
+type counterValue struct {
+	labels []string
+	value  float64
+}
+
+// NewCounterVec returns a counter with the given label names.
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{name: name, help: help, labels: labels, values: map[string]*counterValue{}}
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter with the given label values.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cv, ok := c.values[key]
+	if !ok {
+		cv = &counterValue{labels: labelValues}
+		c.values[key] = cv
+	}
+	cv.value += v
+}
+
+// Value returns the counter with the given label values.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cv, ok := c.values[strings.Join(labelValues, "\xff")]; ok {
+		return cv.value
+	}
+	return 0
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, key := range sortedKeys(c.values) {
+		cv := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, cv.labels), formatFloat(cv.value))
+	}
+}
+
+// HistogramVec is a Prometheus histogram with labels.
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogramValue
+}
+
+type histogramValue struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec returns a histogram with the given upper bucket bounds and label names.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, values: map[string]*histogramValue{}}
+}
+
+// Observe adds v to the histogram with the given label values.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	hv, ok := h.values[key]
+	if !ok {
+		hv = &histogramValue{labels: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.values[key] = hv
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			hv.counts[i]++
+			break
+		}
+	}
+	hv.count++
+	hv.sum += v
+}
+
+// Count returns the number of observations with the given label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if hv, ok := h.values[strings.Join(labelValues, "\xff")]; ok {
+		return hv.count
+	}
+	return 0
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	labels := append(h.labels[:len(h.labels):len(h.labels)], "le")
+	for _, key := range sortedKeys(h.values) {
+		hv := h.values[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += hv.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, append(hv.labels[:len(hv.labels):len(hv.labels)], formatFloat(le))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(labels, append(hv.labels[:len(hv.labels):len(hv.labels)], "+Inf")), hv.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, hv.labels), formatFloat(hv.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, hv.labels), hv.count)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// formatLabels formats label pairs as {name="value",...}, escaping the values.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		b.WriteString(name + `="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
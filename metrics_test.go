@@ -0,0 +1,105 @@
+package claudecodeproxy
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestCounterVec_Write(t *testing.T) {
+	c := NewCounterVec("test_total", "A test counter.", "model", "status")
+	c.Inc("b", "200")
+	c.Add(2.5, "a", "500")
+	c.Inc("b", "200")
+	c.Inc(`q"\`, "200")
+
+	var out strings.Builder
+	c.write(&out)
+	want := `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{model="a",status="500"} 2.5
+test_total{model="b",status="200"} 2
+test_total{model="q\"\\",status="200"} 1
+`
+	if out.String() != want {
+		t.Errorf("Output mismatch:\ngot:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	empty := NewCounterVec("empty_total", "No labels.")
+	out.Reset()
+	empty.write(&out)
+	if !strings.HasSuffix(out.String(), "\nempty_total 0\n") {
+		t.Errorf("Expected an unlabelled counter to start at 0, got:\n%s", out.String())
+	}
+}
+
+func TestHistogramVec_Write(t *testing.T) {
+	h := NewHistogramVec("test_seconds", "A test histogram.", []float64{0.5, 1}, "model")
+	h.Observe(0.25, "m")
+	h.Observe(0.75, "m")
+	h.Observe(3, "m")
+
+	var out strings.Builder
+	h.write(&out)
+	want := `# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{model="m",le="0.5"} 1
+test_seconds_bucket{model="m",le="1"} 2
+test_seconds_bucket{model="m",le="+Inf"} 3
+test_seconds_sum{model="m"} 4
+test_seconds_count{model="m"} 3
+`
+	if out.String() != want {
+		t.Errorf("Output mismatch:\ngot:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestMetrics_WriteTo(t *testing.T) {
+	m := NewMetrics()
+	for i := 0; i < 200; i++ {
+		m.Requests.Inc("claude-sonnet-4", "gpt-4.1", strconv.Itoa(i))
+	}
+	var out strings.Builder
+	n, err := m.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("WriteTo error: %v", err)
+	}
+	// The output is larger than the write buffer, so all of it must be counted.
+	if out.Len() <= 4096 || n != int64(out.Len()) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, out.Len())
+	}
+}
+
+func TestMetrics_ObserveEvents(t *testing.T) {
+	stream := "event: message_start\r\ndata: {\"type\":\"message_start\"}\r\n\r\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"text\",\"text\":\"\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":1,\"content_block\":{\"type\":\"tool_use\",\"id\":\"toolu_1\",\"name\":\"Read\",\"input\":{}}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":1,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{}\"}}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"input_tokens\":10,\"cache_read_input_tokens\":5,\"output_tokens\":7}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}"
+	m := NewMetrics()
+	r := m.ObserveEvents(io.NopCloser(iotest.OneByteReader(strings.NewReader(stream))), time.Now(), "gpt-4.1")
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error: %v", err)
+	}
+	if string(got) != stream {
+		t.Errorf("Expected the stream to pass through unchanged, got %q", got)
+	}
+	if n := m.TimeToFirstToken.Count("gpt-4.1"); n != 1 {
+		t.Errorf("Time to first token count mismatch: got %d, want 1", n)
+	}
+	if v := m.ToolCalls.Value("gpt-4.1"); v != 1 {
+		t.Errorf("Tool calls mismatch: got %v, want 1", v)
+	}
+	if v := m.InputTokens.Value("gpt-4.1"); v != 15 {
+		t.Errorf("Input tokens mismatch: got %v, want 15", v)
+	}
+	if v := m.OutputTokens.Value("gpt-4.1"); v != 7 {
+		t.Errorf("Output tokens mismatch: got %v, want 7", v)
+	}
+}
@@ -1,5 +1,11 @@
 package claudecodeproxy
 
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
 // -------------------- Claude (Anthropic) API Structs --------------------
 
 // ClaudeContentBlockText represents a text content block for Claude API.
@@ -22,6 +28,13 @@ type ClaudeContentBlockToolUse struct {
 	Input map[string]any `json:"input"`
 }
 
+// ClaudeContentBlockThinking represents a thinking content block for Claude API.
+type ClaudeContentBlockThinking struct {
+	Type      string `json:"type"` // always "thinking"
+	Thinking  string `json:"thinking"`
+	Signature string `json:"signature"`
+}
+
 // ClaudeContentBlockToolResult represents a tool result content block for Claude API.
 type ClaudeContentBlockToolResult struct {
 	Type      string `json:"type"` // always "tool_result"
@@ -31,8 +44,9 @@ type ClaudeContentBlockToolResult struct {
 
 // ClaudeSystemContent represents a system content block for Claude API.
 type ClaudeSystemContent struct {
-	Type string `json:"type"` // always "text"
-	Text string `json:"text"`
+	Type         string         `json:"type"` // always "text"
+	Text         string         `json:"text"`
+	CacheControl map[string]any `json:"cache_control,omitempty"`
 }
 
 // ClaudeMessage represents a chat message for Claude API.
@@ -48,9 +62,16 @@ type ClaudeTool struct {
 	InputSchema map[string]any `json:"input_schema"`
 }
 
-// ClaudeThinkingConfig represents the thinking configuration for Claude API.
+// ClaudeThinkingConfig represents the extended thinking configuration for Claude API:
+// {"type": "enabled", "budget_tokens": N} or {"type": "disabled"}.
 type ClaudeThinkingConfig struct {
-	Enabled bool `json:"enabled"`
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// IsEnabled reports whether extended thinking was requested. A nil config is disabled.
+func (c *ClaudeThinkingConfig) IsEnabled() bool {
+	return c != nil && c.Type == "enabled"
 }
 
 // ClaudeMessagesRequest represents the request body for /v1/messages (Claude API).
@@ -107,18 +128,90 @@ type ClaudeMessagesResponse struct {
 	Usage        ClaudeUsage `json:"usage"`
 }
 
+// ClaudeModel represents a model of the /v1/models list (Claude API).
+type ClaudeModel struct {
+	Type        string    `json:"type"` // always "model"
+	ID          string    `json:"id"`
+	DisplayName string    `json:"display_name"`
+	CreatedAt   time.Time `json:"created_at,omitzero"` // zero when the upstream does not report it
+}
+
+// ClaudeModelList represents the response for /v1/models (Claude API).
+type ClaudeModelList struct {
+	Data    []ClaudeModel `json:"data"`
+	HasMore bool          `json:"has_more"`
+	FirstID *string       `json:"first_id"`
+	LastID  *string       `json:"last_id"`
+}
+
 // -------------------- OpenAI/LiteLLM API Structs --------------------
 
 // OAIMessage represents a chat message for OpenAI/LiteLLM API.
 type OAIMessage struct {
-	Role    string              `json:"role"` // "user", "assistant", "system", etc.
-	Content []OAIMessageContent `json:"content"`
-	// Optionally, you can add ToolCalls, Name, etc. if needed
+	Role       string               `json:"role"` // "user", "assistant", "system", "tool"
+	Content    []OAIMessageContent  `json:"content"`
+	ToolCalls  []OAIMessageToolCall `json:"tool_calls,omitempty"`   // assistant messages only
+	ToolCallID string               `json:"tool_call_id,omitempty"` // tool messages only
 }
 
+// UnmarshalJSON decodes a message whose content is a string, an array of parts or null,
+// as sent by OpenAI clients.
+func (m *OAIMessage) UnmarshalJSON(b []byte) error {
+	type plain OAIMessage
+	var raw struct {
+		plain
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	*m = OAIMessage(raw.plain)
+	m.Content = nil
+	var text string
+	switch {
+	case len(raw.Content) == 0 || string(raw.Content) == "null":
+	case json.Unmarshal(raw.Content, &text) == nil:
+		m.Content = []OAIMessageContent{{Type: "text", Text: text}}
+	default:
+		if err := json.Unmarshal(raw.Content, &m.Content); err != nil {
+			return fmt.Errorf("message content: %w", err)
+		}
+	}
+	return nil
+}
+
+// OAIMessageToolCall represents a tool call issued by an assistant message in the conversation history.
+type OAIMessageToolCall struct {
+	ID       string              `json:"id"`
+	Type     string              `json:"type"` // always "function"
+	Function OAIToolCallFunction `json:"function"`
+}
+
+// OAIMessageContent is a content part of an OAI message: a text part or an image_url part.
 type OAIMessageContent struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type     string       `json:"type"` // "text" or "image_url"
+	Text     string       `json:"text"`
+	ImageURL *OAIImageURL `json:"image_url,omitempty"`
+}
+
+// OAIImageURL is the payload of an image_url content part. URL is an http(s) or data: URL.
+type OAIImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// MarshalJSON encodes only the fields belonging to the part's type.
+func (c OAIMessageContent) MarshalJSON() ([]byte, error) {
+	if c.Type == "image_url" {
+		return json.Marshal(struct {
+			Type     string       `json:"type"`
+			ImageURL *OAIImageURL `json:"image_url"`
+		}{c.Type, c.ImageURL})
+	}
+	return json.Marshal(struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}{c.Type, c.Text})
 }
 
 // OAIFunctionTool represents a function tool for OpenAI/LiteLLM API.
@@ -127,26 +220,66 @@ type OAIFunctionTool struct {
 	Function map[string]any `json:"function"`
 }
 
+// OAIStreamOptions represents the stream_options of a streaming OpenAI/LiteLLM request.
+type OAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
 // OAIRequest represents the request body for OpenAI/LiteLLM API.
 type OAIRequest struct {
-	Model       string             `json:"model"`
-	Messages    []OAIMessage       `json:"messages"`
-	MaxTokens   int                `json:"max_tokens"`
-	Temperature *float64           `json:"temperature,omitempty"`
-	TopP        *float64           `json:"top_p,omitempty"`
-	TopK        *int               `json:"top_k,omitempty"`
-	Stop        *[]string          `json:"stop,omitempty"`
-	Tools       *[]OAIFunctionTool `json:"tools,omitempty"`
-	ToolChoice  any                `json:"tool_choice,omitempty"`
-	Stream      bool               `json:"stream"`
-	APIKey      *string            `json:"api_key,omitempty"`
+	Model     string       `json:"model"`
+	Messages  []OAIMessage `json:"messages"`
+	MaxTokens int          `json:"max_tokens,omitempty"`
+	// MaxCompletionTokens is the newer name for MaxTokens used by some OpenAI clients.
+	MaxCompletionTokens int                `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64           `json:"temperature,omitempty"`
+	TopP                *float64           `json:"top_p,omitempty"`
+	TopK                *int               `json:"top_k,omitempty"`
+	Stop                *[]string          `json:"stop,omitempty"`
+	Tools               *[]OAIFunctionTool `json:"tools,omitempty"`
+	ToolChoice          any                `json:"tool_choice,omitempty"`
+	Stream              bool               `json:"stream"`
+	StreamOptions       *OAIStreamOptions  `json:"stream_options,omitempty"`
+	APIKey              *string            `json:"api_key,omitempty"`
+	// ReasoningEffort ("low", "medium" or "high") is only understood by reasoning models.
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	// ResponseFormat, Seed and N are read from OpenAI clients only, to reject what Claude cannot do.
+	ResponseFormat map[string]any `json:"response_format,omitempty"`
+	Seed           *int           `json:"seed,omitempty"`
+	N              *int           `json:"n,omitempty"`
+
+	// ExtraParams are merged into the JSON body, overriding the fields above.
+	ExtraParams map[string]any `json:"-"`
+}
+
+// MarshalJSON encodes the request and merges ExtraParams into the top-level object.
+func (r OAIRequest) MarshalJSON() ([]byte, error) {
+	type plain OAIRequest
+	b, err := json.Marshal(plain(r))
+	if err != nil || len(r.ExtraParams) == 0 {
+		return b, err
+	}
+	var merged map[string]any
+	if err := json.Unmarshal(b, &merged); err != nil {
+		return nil, err
+	}
+	for k, v := range r.ExtraParams {
+		merged[k] = v
+	}
+	return json.Marshal(merged)
 }
 
 // OAIUsage represents token usage statistics for OpenAI/LiteLLM API.
 type OAIUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int                     `json:"prompt_tokens"`
+	CompletionTokens    int                     `json:"completion_tokens"`
+	TotalTokens         int                     `json:"total_tokens"`
+	PromptTokensDetails *OAIPromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+// OAIPromptTokensDetails breaks down the prompt tokens of OAIUsage.
+type OAIPromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
 }
 
 // OAIResponse represents the response body for OpenAI/LiteLLM API.
@@ -158,3 +291,33 @@ type OAIResponse struct {
 	Choices []any    `json:"choices"`
 	Usage   OAIUsage `json:"usage"`
 }
+
+// OAIChoice is a choice of a non-streaming OAIResponse.
+type OAIChoice struct {
+	Index        int              `json:"index"`
+	Message      OAIChoiceMessage `json:"message"`
+	FinishReason string           `json:"finish_reason"`
+}
+
+// OAIChoiceMessage is the assistant message of an OAIChoice.
+type OAIChoiceMessage struct {
+	Role             string               `json:"role"`
+	Content          *string              `json:"content"`
+	ReasoningContent string               `json:"reasoning_content,omitempty"`
+	ToolCalls        []OAIMessageToolCall `json:"tool_calls,omitempty"`
+}
+
+// OAIModel is an entry of the OpenAI /models list. Copilot adds a display name.
+type OAIModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created,omitempty"`
+	OwnedBy string `json:"owned_by"`
+	Name    string `json:"name,omitempty"`
+}
+
+// OAIModelList represents the response for /models (OpenAI API).
+type OAIModelList struct {
+	Object string     `json:"object"`
+	Data   []OAIModel `json:"data"`
+}
@@ -0,0 +1,206 @@
+package claudecodeproxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// finishReasonClaudeToOAI maps a Claude stop_reason to an OpenAI finish_reason.
+func finishReasonClaudeToOAI(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
+
+// usageClaudeToOAI converts Claude usage, which counts cached prompt tokens separately, to OpenAI usage.
+func usageClaudeToOAI(u ClaudeUsage) OAIUsage {
+	usage := OAIUsage{
+		PromptTokens:     u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens,
+		CompletionTokens: u.OutputTokens,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	if u.CacheReadInputTokens > 0 {
+		usage.PromptTokensDetails = &OAIPromptTokensDetails{CachedTokens: u.CacheReadInputTokens}
+	}
+	return usage
+}
+
+// ConvertClaudeResponseToOAI converts a Claude response, as returned by ParseClaudeStreamToResponse,
+// to an OpenAI chat completion.
+func ConvertClaudeResponseToOAI(resp ClaudeMessagesResponse) OAIResponse {
+	msg := OAIChoiceMessage{Role: "assistant"}
+	var text string
+	var hasText bool
+	for _, block := range resp.Content {
+		switch b := block.(type) {
+		case *ClaudeContentBlockText:
+			text += b.Text
+			hasText = true
+		case *ClaudeContentBlockThinking:
+			msg.ReasoningContent += b.Thinking
+		case *ClaudeContentBlockToolUse:
+			args, _ := json.Marshal(b.Input)
+			msg.ToolCalls = append(msg.ToolCalls, OAIMessageToolCall{
+				ID:       b.ID,
+				Type:     "function",
+				Function: OAIToolCallFunction{Name: b.Name, Arguments: string(args)},
+			})
+		}
+	}
+	if hasText {
+		msg.Content = &text
+	}
+	stopReason := ""
+	if resp.StopReason != nil {
+		stopReason = *resp.StopReason
+	}
+	return OAIResponse{
+		ID:      resp.ID,
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   resp.Model,
+		Choices: []any{OAIChoice{
+			Index:        0,
+			Message:      msg,
+			FinishReason: finishReasonClaudeToOAI(stopReason),
+		}},
+		Usage: usageClaudeToOAI(resp.Usage),
+	}
+}
+
+// ConvertClaudeStreamToOAIStream reads Claude streaming events from r, as produced by
+// ConvertOAIStreamToClaudeStream, and writes them to w as OpenAI chat completion chunks, ending
+// with "data: [DONE]". With includeUsage a final chunk carries the token usage, as requested by
+// stream_options.include_usage. An error event is forwarded as an OpenAI error and returned.
+func ConvertClaudeStreamToOAIStream(r io.Reader, w io.Writer, includeUsage bool) error {
+	sse := NewSSEWriter(w)
+	reader := NewSSEReader(r)
+
+	var id, model string
+	created := time.Now().Unix()
+	var usage ClaudeUsage
+	stopReason := ""
+	// toolCalls maps Claude block indices to OpenAI tool call indices.
+	toolCalls := map[int]int{}
+
+	writeChunk := func(choices []any, extra map[string]any) {
+		chunk := map[string]any{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": choices,
+		}
+		for k, v := range extra {
+			chunk[k] = v
+		}
+		sse.WriteEvent("", chunk)
+	}
+	writeDelta := func(delta map[string]any, finishReason any) {
+		writeChunk([]any{map[string]any{
+			"index":         0,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		}}, nil)
+	}
+
+	for {
+		event, err := reader.ReadEvent()
+		if err == io.EOF {
+			return NewAPIError(http.StatusBadGateway, "stream ended before message_stop")
+		}
+		if err != nil {
+			return err
+		}
+		var data struct {
+			Index   int `json:"index"`
+			Message struct {
+				ID    string      `json:"id"`
+				Model string      `json:"model"`
+				Usage ClaudeUsage `json:"usage"`
+			} `json:"message"`
+			ContentBlock struct {
+				Type string `json:"type"`
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			} `json:"content_block"`
+			Delta struct {
+				Type        string  `json:"type"`
+				Text        string  `json:"text"`
+				Thinking    string  `json:"thinking"`
+				PartialJSON string  `json:"partial_json"`
+				StopReason  *string `json:"stop_reason"`
+			} `json:"delta"`
+			Usage ClaudeUsage `json:"usage"`
+			Error ClaudeError `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(event.Data), &data); err != nil {
+			return NewAPIError(http.StatusBadGateway, "malformed %s event: %v", event.Event, err)
+		}
+
+		switch event.Event {
+		case "message_start":
+			id = data.Message.ID
+			model = data.Message.Model
+			usage = data.Message.Usage
+			writeDelta(map[string]any{"role": "assistant", "content": ""}, nil)
+		case "content_block_start":
+			if data.ContentBlock.Type == "tool_use" {
+				index := len(toolCalls)
+				toolCalls[data.Index] = index
+				writeDelta(map[string]any{"tool_calls": []any{map[string]any{
+					"index":    index,
+					"id":       data.ContentBlock.ID,
+					"type":     "function",
+					"function": map[string]any{"name": data.ContentBlock.Name, "arguments": ""},
+				}}}, nil)
+			}
+		case "content_block_delta":
+			switch data.Delta.Type {
+			case "text_delta":
+				writeDelta(map[string]any{"content": data.Delta.Text}, nil)
+			case "thinking_delta":
+				writeDelta(map[string]any{"reasoning_content": data.Delta.Thinking}, nil)
+			case "input_json_delta":
+				writeDelta(map[string]any{"tool_calls": []any{map[string]any{
+					"index":    toolCalls[data.Index],
+					"function": map[string]any{"arguments": data.Delta.PartialJSON},
+				}}}, nil)
+			}
+		case "message_delta":
+			if data.Delta.StopReason != nil {
+				stopReason = *data.Delta.StopReason
+			}
+			if data.Usage.InputTokens > 0 {
+				usage.InputTokens = data.Usage.InputTokens
+			}
+			if data.Usage.OutputTokens > 0 {
+				usage.OutputTokens = data.Usage.OutputTokens
+			}
+			if data.Usage.CacheReadInputTokens > 0 {
+				usage.CacheReadInputTokens = data.Usage.CacheReadInputTokens
+			}
+		case "message_stop":
+			writeDelta(map[string]any{}, finishReasonClaudeToOAI(stopReason))
+			if includeUsage {
+				writeChunk([]any{}, map[string]any{"usage": usageClaudeToOAI(usage)})
+			}
+			sse.WriteData("[DONE]")
+			return sse.Err()
+		case "error":
+			apiErr := &APIError{Status: statusForErrorType(data.Error.Type), Type: data.Error.Type, Message: data.Error.Message}
+			sse.WriteEvent("", apiErr.OAIResponse())
+			return apiErr
+		}
+		if err := sse.Err(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package claudecodeproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConvertClaudeStreamToOAIStream(t *testing.T) {
+	upstream := oaiChunks("tool_calls",
+		`{"content":"Let me check."}`,
+		`{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"Read","arguments":"{\"path\":"}}]}`,
+		`{"tool_calls":[{"index":0,"function":{"arguments":"\"a.go\"}"}}]}`,
+	)
+	upstream = strings.Replace(upstream, "data: [DONE]", `data: {"id":"cmpl-abc","choices":[],"usage":{"prompt_tokens":30,"completion_tokens":7,"total_tokens":37,"prompt_tokens_details":{"cached_tokens":20}}}`+"\n\ndata: [DONE]", 1)
+	var claudeStream bytes.Buffer
+	if err := ConvertOAIStreamToClaudeStream(strings.NewReader(upstream), &claudeStream, "gpt-4.1"); err != nil {
+		t.Fatalf("ConvertOAIStreamToClaudeStream error: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := ConvertClaudeStreamToOAIStream(&claudeStream, &out, true); err != nil {
+		t.Fatalf("ConvertClaudeStreamToOAIStream error: %v", err)
+	}
+	if !strings.HasSuffix(out.String(), "data: [DONE]\n\n") {
+		t.Errorf("Expected the stream to end with [DONE], got: %s", out.String())
+	}
+
+	// Reassemble the chunks the way an OpenAI client would.
+	var content, args, toolID, finish string
+	var usage *OAIUsage
+	reader := NewSSEReader(&out)
+	for {
+		ev, err := reader.ReadEvent()
+		if err != nil {
+			break
+		}
+		if ev.Event != "" {
+			t.Errorf("OpenAI chunks must not have event names, got %q", ev.Event)
+		}
+		if ev.Data == "[DONE]" {
+			break
+		}
+		var chunk struct {
+			Object  string `json:"object"`
+			Choices []struct {
+				Delta struct {
+					Content   string        `json:"content"`
+					ToolCalls []OAIToolCall `json:"tool_calls"`
+				} `json:"delta"`
+				FinishReason *string `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *OAIUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(ev.Data), &chunk); err != nil {
+			t.Fatalf("Unmarshal chunk error: %v", err)
+		}
+		if chunk.Object != "chat.completion.chunk" {
+			t.Errorf("Object mismatch: got %q", chunk.Object)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, c := range chunk.Choices {
+			content += c.Delta.Content
+			for _, tc := range c.Delta.ToolCalls {
+				if tc.Id != "" {
+					toolID = tc.Id
+				}
+				args += tc.Function.Arguments
+			}
+			if c.FinishReason != nil {
+				finish = *c.FinishReason
+			}
+		}
+	}
+	if content != "Let me check." || !strings.HasPrefix(toolID, ToolUseIDPrefix) || args != `{"path":"a.go"}` || finish != "tool_calls" {
+		t.Errorf("Reassembled stream mismatch: content %q, tool %q, args %q, finish %q", content, toolID, args, finish)
+	}
+	wantUsage := &OAIUsage{PromptTokens: 30, CompletionTokens: 7, TotalTokens: 37, PromptTokensDetails: &OAIPromptTokensDetails{CachedTokens: 20}}
+	if !reflect.DeepEqual(usage, wantUsage) {
+		t.Errorf("Usage mismatch: got %+v, want %+v", usage, wantUsage)
+	}
+}
+
+func TestConvertClaudeStreamToOAIStream_Error(t *testing.T) {
+	claudeStream := `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","model":"gpt-4.1","usage":{}}}
+
+event: error
+data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}
+
+`
+	var out bytes.Buffer
+	err := ConvertClaudeStreamToOAIStream(strings.NewReader(claudeStream), &out, false)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Type != "overloaded_error" {
+		t.Fatalf("Expected an overloaded APIError, got %v", err)
+	}
+	if !strings.Contains(out.String(), `data: {"error":{"message":"Overloaded","type":"overloaded_error","code":null}}`) {
+		t.Errorf("Expected an OpenAI error chunk, got: %s", out.String())
+	}
+}
+
+func TestConvertClaudeResponseToOAI(t *testing.T) {
+	stop := "tool_use"
+	resp := ConvertClaudeResponseToOAI(ClaudeMessagesResponse{
+		ID:    "msg_1",
+		Model: "gpt-4.1",
+		Content: []any{
+			&ClaudeContentBlockThinking{Type: "thinking", Thinking: "Hmm."},
+			&ClaudeContentBlockText{Type: "text", Text: "Reading."},
+			&ClaudeContentBlockToolUse{Type: "tool_use", ID: "call_1", Name: "Read", Input: map[string]any{"path": "a.go"}},
+		},
+		StopReason: &stop,
+		Usage:      ClaudeUsage{InputTokens: 5, OutputTokens: 3},
+	})
+	if resp.Object != "chat.completion" || resp.ID != "msg_1" || resp.Usage.TotalTokens != 8 {
+		t.Errorf("Response mismatch: %+v", resp)
+	}
+	choice := resp.Choices[0].(OAIChoice)
+	content := "Reading."
+	want := OAIChoice{
+		Message: OAIChoiceMessage{
+			Role:             "assistant",
+			Content:          &content,
+			ReasoningContent: "Hmm.",
+			ToolCalls: []OAIMessageToolCall{{
+				ID:       "call_1",
+				Type:     "function",
+				Function: OAIToolCallFunction{Name: "Read", Arguments: `{"path":"a.go"}`},
+			}},
+		},
+		FinishReason: "tool_calls",
+	}
+	if !reflect.DeepEqual(choice, want) {
+		t.Errorf("Choice mismatch: got %+v, want %+v", choice, want)
+	}
+}
+
+func TestConvertOAIToClaude_ToolsAndSystem(t *testing.T) {
+	var req OAIRequest
+	err := json.Unmarshal([]byte(`{
+		"model": "gpt-4.1",
+		"messages": [
+			{"role": "system", "content": "Be brief."},
+			{"role": "user", "content": [{"type": "text", "text": "Read both"}, {"type": "image_url", "image_url": {"url": "data:image/png;base64,AAAA"}}]},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "Read", "arguments": "{\"path\":\"a.go\"}"}},
+				{"id": "call_2", "type": "function", "function": {"name": "Read", "arguments": "{\"path\":\"b.go\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "package a"},
+			{"role": "tool", "tool_call_id": "call_2", "content": "package b"},
+			{"role": "assistant", "content": "Both are packages."}
+		],
+		"tool_choice": "required",
+		"max_completion_tokens": 100
+	}`), &req)
+	if err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	claudeReq, err := ConvertOAIToClaude(req)
+	if err != nil {
+		t.Fatalf("ConvertOAIToClaude error: %v", err)
+	}
+	if claudeReq.System != "Be brief." || claudeReq.MaxTokens != 100 {
+		t.Errorf("Request mismatch: system %v, max_tokens %d", claudeReq.System, claudeReq.MaxTokens)
+	}
+	if claudeReq.ToolChoice == nil || (*claudeReq.ToolChoice)["type"] != "any" {
+		t.Errorf("Tool choice mismatch: got %v", claudeReq.ToolChoice)
+	}
+	want := []ClaudeMessage{
+		{Role: "user", Content: []any{
+			ClaudeContentBlockText{Type: "text", Text: "Read both"},
+			ClaudeContentBlockImage{Type: "image", Source: map[string]any{"type": "base64", "media_type": "image/png", "data": "AAAA"}},
+		}},
+		{Role: "assistant", Content: []any{
+			ClaudeContentBlockToolUse{Type: "tool_use", ID: "call_1", Name: "Read", Input: map[string]any{"path": "a.go"}},
+			ClaudeContentBlockToolUse{Type: "tool_use", ID: "call_2", Name: "Read", Input: map[string]any{"path": "b.go"}},
+		}},
+		{Role: "user", Content: []any{
+			ClaudeContentBlockToolResult{Type: "tool_result", ToolUseID: "call_1", Content: "package a"},
+			ClaudeContentBlockToolResult{Type: "tool_result", ToolUseID: "call_2", Content: "package b"},
+		}},
+		{Role: "assistant", Content: []ClaudeContentBlockText{{Type: "text", Text: "Both are packages."}}},
+	}
+	if !reflect.DeepEqual(claudeReq.Messages, want) {
+		t.Errorf("Messages mismatch:\ngot  %+v\nwant %+v", claudeReq.Messages, want)
+	}
+
+	// Converting back yields the OpenAI tool messages again.
+	oaiReq, err := ConvertClaudeToOAI(claudeReq)
+	if err != nil {
+		t.Fatalf("ConvertClaudeToOAI error: %v", err)
+	}
+	var roles []string
+	for _, m := range oaiReq.Messages {
+		roles = append(roles, m.Role)
+	}
+	if got := strings.Join(roles, ","); got != "system,user,assistant,tool,tool,assistant" {
+		t.Errorf("Roundtrip roles mismatch: got %s", got)
+	}
+}
+
+func TestToolChoice_RoundTrip(t *testing.T) {
+	for _, choice := range []any{
+		"auto",
+		"none",
+		"required",
+		map[string]any{"type": "function", "function": map[string]any{"name": "Read"}},
+	} {
+		claudeChoice := ConvertToolChoiceOAIToClaude(choice)
+		if got := ConvertToolChoiceClaudeToOAI(*claudeChoice); !reflect.DeepEqual(got, choice) {
+			t.Errorf("Roundtrip tool_choice mismatch: got %v, want %v (Claude %v)", got, choice, *claudeChoice)
+		}
+	}
+}
+
+func TestConvertOAIToClaude_UnsupportedParameters(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"TextResponseFormat", `{"response_format":{"type":"text"}}`, false},
+		{"JSONResponseFormat", `{"response_format":{"type":"json_object"}}`, true},
+		{"JSONSchema", `{"response_format":{"type":"json_schema","json_schema":{"name":"x"}}}`, true},
+		{"Seed", `{"seed":42}`, true},
+		{"OneChoice", `{"n":1}`, false},
+		{"ManyChoices", `{"n":2}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req OAIRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatalf("Unmarshal error: %v", err)
+			}
+			if _, err := ConvertOAIToClaude(req); (err != nil) != tt.wantErr {
+				t.Errorf("ConvertOAIToClaude error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
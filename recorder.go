@@ -0,0 +1,235 @@
+package claudecodeproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// redactedHeaders are replaced with "REDACTED" in recordings.
+var redactedHeaders = []string{"Authorization", "X-Api-Key", "Cookie", "Proxy-Authorization"}
+
+// Recorder appends each proxied exchange to a JSONL file, one Recording per line.
+type Recorder struct {
+	// MaxBodyBytes truncates recorded request bodies; zero records them in full.
+	MaxBodyBytes int
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewRecorder returns a Recorder writing to w.
+func NewRecorder(w io.Writer, maxBodyBytes int) *Recorder {
+	return &Recorder{w: w, MaxBodyBytes: maxBodyBytes}
+}
+
+// OpenRecorder returns a Recorder appending to the file at path.
+func OpenRecorder(path string, maxBodyBytes int) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(f, maxBodyBytes), nil
+}
+
+// Recording is a recorded exchange: the client's request, the request sent upstream, the raw
+// upstream stream and the Claude events returned to the client.
+type Recording struct {
+	Time     time.Time   `json:"time"`
+	Endpoint string      `json:"endpoint"`
+	Header   http.Header `json:"header"`
+	// ClaudeRequest is the Claude request, as sent by the client or converted from an OpenAI one.
+	ClaudeRequest json.RawMessage `json:"claude_request"`
+	// OAIRequest is the request sent to an OpenAI-compatible upstream.
+	OAIRequest json.RawMessage `json:"oai_request,omitempty"`
+	// MessagesHash is the MessagesHash of OAIRequest, kept for replay when the body is truncated.
+	MessagesHash string `json:"messages_hash,omitempty"`
+	// UpstreamChunks holds the data of every upstream SSE event, including "[DONE]".
+	UpstreamChunks []string `json:"upstream_chunks"`
+	// ClaudeEvents holds the Claude events returned to the client.
+	ClaudeEvents []RecordedEvent `json:"claude_events"`
+	Error        string          `json:"error,omitempty"`
+	// FirstChunkMS is the time from the request to the first upstream byte.
+	FirstChunkMS int64 `json:"first_chunk_ms"`
+	DurationMS   int64 `json:"duration_ms"`
+
+	recorder      *Recorder
+	mu            sync.Mutex
+	upstream      bytes.Buffer
+	events        bytes.Buffer
+	upstreamStart bool
+}
+
+// RecordedEvent is a Claude SSE event of a Recording.
+type RecordedEvent struct {
+	Event string `json:"event"`
+	Data  string `json:"data"`
+}
+
+// Start begins a Recording of a request with the given body. It returns nil for a nil Recorder,
+// and all Recording methods do nothing on nil.
+func (r *Recorder) Start(req *http.Request, body []byte) *Recording {
+	if r == nil {
+		return nil
+	}
+	header := req.Header.Clone()
+	for _, name := range redactedHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, "REDACTED")
+		}
+	}
+	return &Recording{
+		Time:          time.Now(),
+		Endpoint:      req.URL.Path,
+		Header:        header,
+		ClaudeRequest: r.truncate(body),
+		recorder:      r,
+	}
+}
+
+// truncate shortens a body to MaxBodyBytes. A truncated body is recorded as a JSON string.
+func (r *Recorder) truncate(body []byte) json.RawMessage {
+	if r.MaxBodyBytes <= 0 || len(body) <= r.MaxBodyBytes {
+		if json.Valid(body) {
+			return body
+		}
+	} else {
+		body = append(body[:r.MaxBodyBytes:r.MaxBodyBytes], fmt.Sprintf("...[truncated %d bytes]", len(body)-r.MaxBodyBytes)...)
+	}
+	s, _ := json.Marshal(string(body))
+	return s
+}
+
+// SetClaudeRequest records the Claude request a non-Claude client request was converted to.
+func (rec *Recording) SetClaudeRequest(req ClaudeMessagesRequest) {
+	if rec == nil {
+		return
+	}
+	body, _ := json.Marshal(req)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.ClaudeRequest = rec.recorder.truncate(body)
+}
+
+// SetOAIRequest records the request sent upstream.
+func (rec *Recording) SetOAIRequest(body []byte) {
+	if rec == nil {
+		return
+	}
+	var req OAIRequest
+	json.Unmarshal(body, &req)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.OAIRequest = rec.recorder.truncate(body)
+	rec.MessagesHash = MessagesHash(req)
+}
+
+// SetError records the error the exchange failed with.
+func (rec *Recording) SetError(err error) {
+	if rec == nil || err == nil {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.Error = err.Error()
+}
+
+// TeeUpstream returns a reader that records what is read from the upstream body r.
+func (rec *Recording) TeeUpstream(r io.ReadCloser) io.ReadCloser {
+	if rec == nil {
+		return r
+	}
+	return &recordingReader{ReadCloser: r, rec: rec, buf: &rec.upstream, upstream: true}
+}
+
+// TeeEvents returns a reader that records the Claude events read from r.
+func (rec *Recording) TeeEvents(r io.ReadCloser) io.ReadCloser {
+	if rec == nil {
+		return r
+	}
+	return &recordingReader{ReadCloser: r, rec: rec, buf: &rec.events}
+}
+
+// Finish completes the Recording and appends it to the recorder's file.
+func (rec *Recording) Finish() error {
+	if rec == nil {
+		return nil
+	}
+	rec.mu.Lock()
+	rec.DurationMS = time.Since(rec.Time).Milliseconds()
+	rec.UpstreamChunks = []string{}
+	for _, ev := range readEvents(rec.upstream.Bytes()) {
+		rec.UpstreamChunks = append(rec.UpstreamChunks, ev.Data)
+	}
+	rec.ClaudeEvents = []RecordedEvent{}
+	for _, ev := range readEvents(rec.events.Bytes()) {
+		rec.ClaudeEvents = append(rec.ClaudeEvents, RecordedEvent{Event: ev.Event, Data: ev.Data})
+	}
+	line, err := json.Marshal(rec)
+	rec.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	r := rec.recorder
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.w.Write(append(line, '\n'))
+	return err
+}
+
+// readEvents parses the SSE events of a recorded stream, ignoring a truncated last event.
+func readEvents(b []byte) []SSEEvent {
+	var events []SSEEvent
+	reader := NewSSEReader(bytes.NewReader(b))
+	for {
+		ev, err := reader.ReadEvent()
+		if err != nil {
+			return events
+		}
+		events = append(events, ev)
+	}
+}
+
+type recordingReader struct {
+	io.ReadCloser
+	rec      *Recording
+	buf      *bytes.Buffer
+	upstream bool
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.rec.mu.Lock()
+		if r.upstream && !r.rec.upstreamStart {
+			r.rec.upstreamStart = true
+			r.rec.FirstChunkMS = time.Since(r.rec.Time).Milliseconds()
+		}
+		r.buf.Write(p[:n])
+		r.rec.mu.Unlock()
+	}
+	return n, err
+}
+
+type recordingKey struct{}
+
+// WithRecording returns a context carrying rec, so that backends can add to it.
+func WithRecording(ctx context.Context, rec *Recording) context.Context {
+	if rec == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, recordingKey{}, rec)
+}
+
+// RecordingFrom returns the Recording of ctx, or nil.
+func RecordingFrom(ctx context.Context) *Recording {
+	rec, _ := ctx.Value(recordingKey{}).(*Recording)
+	return rec
+}
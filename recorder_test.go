@@ -0,0 +1,118 @@
+package claudecodeproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecorder_RecordsExchange(t *testing.T) {
+	upstream := oaiChunks("stop", `{"content":"Hello"}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, upstream)
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	recorder := NewRecorder(&out, 0)
+	body := []byte(`{"model":"claude-3-haiku","max_tokens":64,"messages":[{"role":"user","content":"Hi"}]}`)
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("X-Api-Key", "sk-secret")
+	req.Header.Set("Anthropic-Version", "2023-06-01")
+
+	rec := recorder.Start(req, body)
+	backend := &OpenAIBackend{URL: srv.URL}
+	var claudeReq ClaudeMessagesRequest
+	json.Unmarshal(body, &claudeReq)
+	events, err := backend.Messages(WithRecording(context.Background(), rec), BackendRequest{
+		Claude:        claudeReq,
+		Route:         ModelRoute{Upstream: "gpt-4o-mini"},
+		ResponseModel: claudeReq.Model,
+	})
+	if err != nil {
+		t.Fatalf("Messages error: %v", err)
+	}
+	events = rec.TeeEvents(events)
+	io.Copy(io.Discard, events)
+	events.Close()
+	if err := rec.Finish(); err != nil {
+		t.Fatalf("Finish error: %v", err)
+	}
+
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Fatalf("Expected one JSONL line, got: %s", out.String())
+	}
+	if strings.Contains(out.String(), "secret") {
+		t.Errorf("Credentials leaked into the recording: %s", out.String())
+	}
+	var got Recording
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+	if got.Endpoint != "/v1/messages" || got.Header.Get("Authorization") != "REDACTED" || got.Header.Get("Anthropic-Version") != "2023-06-01" {
+		t.Errorf("Request metadata mismatch: %s", out.String())
+	}
+	if !bytes.Equal(got.ClaudeRequest, body) {
+		t.Errorf("Claude request mismatch: got %s", got.ClaudeRequest)
+	}
+	var oaiReq OAIRequest
+	if err := json.Unmarshal(got.OAIRequest, &oaiReq); err != nil || oaiReq.Model != "gpt-4o-mini" {
+		t.Errorf("OAI request mismatch: got %s", got.OAIRequest)
+	}
+	if len(got.UpstreamChunks) != 3 || got.UpstreamChunks[2] != "[DONE]" {
+		t.Errorf("Upstream chunks mismatch: got %q", got.UpstreamChunks)
+	}
+	var names []string
+	for _, ev := range got.ClaudeEvents {
+		names = append(names, ev.Event)
+	}
+	want := "message_start,ping,content_block_start,content_block_delta,content_block_stop,message_delta,message_stop"
+	if strings.Join(names, ",") != want {
+		t.Errorf("Claude events mismatch: got %v", names)
+	}
+}
+
+func TestRecorder_Truncate(t *testing.T) {
+	tests := []struct {
+		name string
+		max  int
+		body string
+		want string
+	}{
+		{"Unlimited", 0, `{"a":"0123456789"}`, `{"a":"0123456789"}`},
+		{"BelowLimit", 100, `{"a":1}`, `{"a":1}`},
+		{"Truncated", 8, `{"a":"0123456789"}`, `"{\"a\":\"01...[truncated 10 bytes]"`},
+		{"NotJSON", 0, `oops`, `"oops"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(NewRecorder(io.Discard, tt.max).truncate([]byte(tt.body))); got != tt.want {
+				t.Errorf("truncate mismatch: got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecording_Nil(t *testing.T) {
+	var recorder *Recorder
+	rec := recorder.Start(httptest.NewRequest(http.MethodPost, "/v1/messages", nil), nil)
+	if rec != nil {
+		t.Fatal("Expected no recording without a recorder")
+	}
+	// All methods are no-ops.
+	rec.SetOAIRequest([]byte(`{}`))
+	rec.SetError(io.EOF)
+	body := io.NopCloser(strings.NewReader("data"))
+	if rec.TeeUpstream(body) != body || rec.Finish() != nil {
+		t.Error("Expected a nil recording to pass everything through")
+	}
+	if RecordingFrom(WithRecording(context.Background(), rec)) != nil {
+		t.Error("Expected no recording in the context")
+	}
+}
@@ -0,0 +1,166 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Replay match modes.
+const (
+	// ReplayMatchHash replays the recording whose request has the same MessagesHash.
+	ReplayMatchHash = "hash"
+	// ReplayMatchSequence replays the recordings in the order they were recorded.
+	ReplayMatchSequence = "sequence"
+)
+
+// MessagesHash returns a hash of the messages of req. Tool call IDs, which differ from run to
+// run, are left out, so the same conversation hashes the same.
+func MessagesHash(req OAIRequest) string {
+	messages := make([]OAIMessage, len(req.Messages))
+	for i, m := range req.Messages {
+		m.ToolCallID = ""
+		if len(m.ToolCalls) > 0 {
+			calls := make([]OAIMessageToolCall, len(m.ToolCalls))
+			for j, tc := range m.ToolCalls {
+				tc.ID = ""
+				calls[j] = tc
+			}
+			m.ToolCalls = calls
+		}
+		messages[i] = m
+	}
+	b, _ := json.Marshal(messages)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// ReplayBackend serves requests from recorded upstream streams instead of an upstream API.
+// Requests are converted as for OpenAIBackend and matched against the recordings, whose upstream
+// chunks are then replayed through ConvertOAIStreamToClaudeStream.
+type ReplayBackend struct {
+	Options ConvertOptions
+	// Match is ReplayMatchHash or ReplayMatchSequence.
+	Match string
+	// Strict fails requests that match no recording. Otherwise they get the next recording in
+	// sequence, starting over once all have been replayed.
+	Strict bool
+
+	mu         sync.Mutex
+	recordings []*Recording
+	byHash     map[string][]int
+	used       []bool
+	next       int
+}
+
+// NewReplayBackend returns a ReplayBackend for recordings. Recordings without upstream chunks,
+// such as failed requests, are skipped.
+func NewReplayBackend(recordings []*Recording, match string, strict bool) (*ReplayBackend, error) {
+	if match != ReplayMatchHash && match != ReplayMatchSequence {
+		return nil, fmt.Errorf("unknown replay match mode %q", match)
+	}
+	b := &ReplayBackend{Match: match, Strict: strict, byHash: map[string][]int{}}
+	for _, rec := range recordings {
+		if len(rec.UpstreamChunks) == 0 {
+			continue
+		}
+		hash := rec.MessagesHash
+		if hash == "" {
+			var req OAIRequest
+			if json.Unmarshal(rec.OAIRequest, &req) == nil {
+				hash = MessagesHash(req)
+			}
+		}
+		if hash != "" {
+			b.byHash[hash] = append(b.byHash[hash], len(b.recordings))
+		}
+		b.recordings = append(b.recordings, rec)
+	}
+	if len(b.recordings) == 0 {
+		return nil, fmt.Errorf("no replayable recordings")
+	}
+	b.used = make([]bool, len(b.recordings))
+	return b, nil
+}
+
+// LoadReplayBackend reads the recordings of a JSONL file written by Recorder.
+func LoadReplayBackend(path, match string, strict bool) (*ReplayBackend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recordings []*Recording
+	dec := json.NewDecoder(f)
+	for {
+		rec := new(Recording)
+		if err := dec.Decode(rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("read recordings %s: %w", path, err)
+		}
+		recordings = append(recordings, rec)
+	}
+	b, err := NewReplayBackend(recordings, match, strict)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// Messages implements Backend.
+func (b *ReplayBackend) Messages(ctx context.Context, req BackendRequest) (io.ReadCloser, error) {
+	oaiReq, _, err := openAIRequest(ctx, req, b.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := MessagesHash(oaiReq)
+	recording := b.find(hash)
+	if recording == nil {
+		return nil, NewAPIError(http.StatusBadGateway, "replay: no recording matches the request (messages hash %s)", hash)
+	}
+
+	var stream strings.Builder
+	for _, chunk := range recording.UpstreamChunks {
+		stream.WriteString("data: " + chunk + "\n\n")
+	}
+	return claudeEvents(ctx, io.NopCloser(strings.NewReader(stream.String())), req, b.Options), nil
+}
+
+// find returns the recording to replay for a request, or nil.
+func (b *ReplayBackend) find(hash string) *Recording {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.Match == ReplayMatchHash {
+		// Identical requests replay their recordings in order, then the last one again.
+		candidates := b.byHash[hash]
+		for _, i := range candidates {
+			if !b.used[i] {
+				b.used[i] = true
+				return b.recordings[i]
+			}
+		}
+		if len(candidates) > 0 {
+			return b.recordings[candidates[len(candidates)-1]]
+		}
+		if b.Strict {
+			return nil
+		}
+	}
+	if b.next >= len(b.recordings) {
+		if b.Strict {
+			return nil
+		}
+		b.next = 0
+	}
+	b.next++
+	return b.recordings[b.next-1]
+}
@@ -0,0 +1,159 @@
+package claudecodeproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// userRequest returns a Claude request with a single user message.
+func userRequest(text string) ClaudeMessagesRequest {
+	return ClaudeMessagesRequest{
+		Model:     "claude-3-haiku",
+		MaxTokens: 64,
+		Messages:  []ClaudeMessage{{Role: "user", Content: text}},
+	}
+}
+
+// replayText runs a request through b and returns the text of the replayed response.
+func replayText(t *testing.T, b Backend, req ClaudeMessagesRequest) (string, error) {
+	t.Helper()
+	events, err := b.Messages(context.Background(), BackendRequest{Claude: req, ResponseModel: req.Model})
+	if err != nil {
+		return "", err
+	}
+	defer events.Close()
+	resp, err := ParseClaudeStreamToResponse(events)
+	if err != nil {
+		t.Fatalf("ParseClaudeStreamToResponse error: %v", err)
+	}
+	var text string
+	for _, block := range resp.Content {
+		if tb, ok := block.(*ClaudeContentBlockText); ok {
+			text += tb.Text
+		}
+	}
+	return text, nil
+}
+
+// recordAnswers records one exchange per question against an upstream answering with "A:" and
+// the question, and returns the JSONL recording.
+func recordAnswers(t *testing.T, questions ...string) []byte {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OAIRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		answer, _ := json.Marshal("A:" + req.Messages[0].Content[0].Text)
+		io.WriteString(w, oaiChunks("stop", `{"content":`+string(answer)+`}`))
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	recorder := NewRecorder(&out, 0)
+	backend := &OpenAIBackend{URL: srv.URL}
+	for _, q := range questions {
+		rec := recorder.Start(httptest.NewRequest(http.MethodPost, "/v1/messages", nil), nil)
+		events, err := backend.Messages(WithRecording(context.Background(), rec), BackendRequest{Claude: userRequest(q)})
+		if err != nil {
+			t.Fatalf("Messages error: %v", err)
+		}
+		io.Copy(io.Discard, events)
+		events.Close()
+		rec.Finish()
+	}
+	return out.Bytes()
+}
+
+func loadTestReplay(t *testing.T, recording []byte, match string, strict bool) *ReplayBackend {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "record.jsonl")
+	os.WriteFile(path, recording, 0o600)
+	b, err := LoadReplayBackend(path, match, strict)
+	if err != nil {
+		t.Fatalf("LoadReplayBackend error: %v", err)
+	}
+	return b
+}
+
+func TestReplayBackend_Hash(t *testing.T) {
+	b := loadTestReplay(t, recordAnswers(t, "one", "two"), ReplayMatchHash, true)
+	for _, q := range []string{"two", "one", "two"} {
+		got, err := replayText(t, b, userRequest(q))
+		if err != nil {
+			t.Fatalf("Replay %q error: %v", q, err)
+		}
+		if got != "A:"+q {
+			t.Errorf("Replay %q mismatch: got %q", q, got)
+		}
+	}
+	_, err := replayText(t, b, userRequest("three"))
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !strings.Contains(apiErr.Message, "no recording matches") {
+		t.Errorf("Expected a strict mode error, got %v", err)
+	}
+
+	// Without strict mode unmatched requests get the recordings in sequence.
+	b.Strict = false
+	if got, err := replayText(t, b, userRequest("three")); err != nil || got != "A:one" {
+		t.Errorf("Non-strict replay mismatch: got %q, %v", got, err)
+	}
+}
+
+func TestReplayBackend_Sequence(t *testing.T) {
+	b := loadTestReplay(t, recordAnswers(t, "one", "two"), ReplayMatchSequence, true)
+	for _, want := range []string{"A:one", "A:two"} {
+		if got, err := replayText(t, b, userRequest("anything")); err != nil || got != want {
+			t.Errorf("Sequence replay mismatch: got %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := replayText(t, b, userRequest("anything")); err == nil {
+		t.Error("Expected an error once the recordings are exhausted in strict mode")
+	}
+	b.Strict = false
+	if got, _ := replayText(t, b, userRequest("anything")); got != "A:one" {
+		t.Errorf("Expected the sequence to start over, got %q", got)
+	}
+}
+
+func TestReplayBackend_TruncatedRecording(t *testing.T) {
+	// The recorded hash still matches when the request body was truncated.
+	recording := recordAnswers(t, "one")
+	var rec Recording
+	json.Unmarshal(recording, &rec)
+	rec.OAIRequest = json.RawMessage(`"{\"model\":...[truncated]"`)
+	b, err := NewReplayBackend([]*Recording{&rec}, ReplayMatchHash, true)
+	if err != nil {
+		t.Fatalf("NewReplayBackend error: %v", err)
+	}
+	if got, err := replayText(t, b, userRequest("one")); err != nil || got != "A:one" {
+		t.Errorf("Replay mismatch: got %q, %v", got, err)
+	}
+}
+
+func TestMessagesHash_IgnoresToolCallIDs(t *testing.T) {
+	request := func(id string) OAIRequest {
+		return OAIRequest{Messages: []OAIMessage{
+			{Role: "assistant", ToolCalls: []OAIMessageToolCall{{ID: id, Type: "function", Function: OAIToolCallFunction{Name: "Read", Arguments: "{}"}}}},
+			{Role: "tool", ToolCallID: id, Content: []OAIMessageContent{{Type: "text", Text: "ok"}}},
+		}}
+	}
+	a, b := request("call_1"), request("call_2")
+	if MessagesHash(a) != MessagesHash(b) {
+		t.Error("Expected tool call IDs not to change the hash")
+	}
+	if a.Messages[0].ToolCalls[0].ID != "call_1" {
+		t.Error("MessagesHash must not modify the request")
+	}
+	b.Messages[1].Content[0].Text = "failed"
+	if MessagesHash(a) == MessagesHash(b) {
+		t.Error("Expected different content to change the hash")
+	}
+}
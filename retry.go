@@ -0,0 +1,176 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryTransport retries upstream requests that fail with a connection error or a
+// retryable status (408, 429, 5xx overload and gateway errors). It waits for the
+// upstream's Retry-After when given and uses jittered exponential backoff otherwise.
+//
+// Retries happen inside RoundTrip, before the response is handed to the caller, so nothing
+// has been streamed to the client yet. Once a response is returned it is never retried.
+type RetryTransport struct {
+	// Base performs the requests. Nil uses http.DefaultTransport.
+	Base http.RoundTripper
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	// BaseDelay is the backoff before the first retry; it doubles with every retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff. A Retry-After longer than MaxDelay is not waited for and
+	// the response is returned as is, so the client can decide when to try again.
+	MaxDelay time.Duration
+	// OnRetry, when set, is called before every retry of req.
+	OnRetry func(req *http.Request)
+
+	sleep  func(ctx context.Context, d time.Duration) error
+	jitter func(n int64) int64
+	now    func() time.Time
+}
+
+// NewRetryTransport returns a RetryTransport around base with a 500ms base delay and a 30s cap.
+func NewRetryTransport(base http.RoundTripper, maxRetries int) *RetryTransport {
+	return &RetryTransport{
+		Base:       base,
+		MaxRetries: maxRetries,
+		BaseDelay:  500 * time.Millisecond,
+		MaxDelay:   30 * time.Second,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// Requests whose body cannot be replayed are only attempted once.
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	logger := LoggerFrom(req.Context()).With("method", req.Method, "url", req.URL.Redacted())
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		resp, err := base.RoundTrip(attemptReq)
+		if attempt >= t.MaxRetries || !replayable || req.Context().Err() != nil || !retryable(resp, err) {
+			if attempt > 0 {
+				logger.Info("Upstream request finished after retries", "result", describeAttempt(resp, err), "retries", attempt)
+			}
+			return resp, err
+		}
+
+		delay := t.backoff(attempt)
+		if resp != nil {
+			if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), t.timeNow()); ok {
+				if t.MaxDelay > 0 && wait > t.MaxDelay {
+					logger.Warn("Upstream Retry-After exceeds the retry limit, not retrying", "result", describeAttempt(resp, err), "retry_after", wait, "max_delay", t.MaxDelay)
+					return resp, nil
+				}
+				delay = wait
+			}
+			// Drain the body so the connection can be reused.
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+		logger.Warn("Retrying upstream request", "result", describeAttempt(resp, err), "delay", delay.Round(time.Millisecond), "retry", attempt+1, "max_retries", t.MaxRetries)
+		if t.OnRetry != nil {
+			t.OnRetry(req)
+		}
+		if err := t.wait(req.Context(), delay); err != nil {
+			return nil, err
+		}
+
+		attemptReq = req.Clone(req.Context())
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+	}
+}
+
+// backoff returns the jittered delay before retry number attempt+1: a random duration
+// between half and all of BaseDelay*2^attempt, capped at MaxDelay.
+func (t *RetryTransport) backoff(attempt int) time.Duration {
+	d := t.BaseDelay
+	for i := 0; i < attempt && (t.MaxDelay <= 0 || d < t.MaxDelay); i++ {
+		d *= 2
+	}
+	if t.MaxDelay > 0 && d > t.MaxDelay {
+		d = t.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	jitter := t.jitter
+	if jitter == nil {
+		jitter = rand.Int64N
+	}
+	half := int64(d / 2)
+	return time.Duration(half + jitter(int64(d)-half+1))
+}
+
+func (t *RetryTransport) wait(ctx context.Context, d time.Duration) error {
+	if t.sleep != nil {
+		return t.sleep(ctx, d)
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (t *RetryTransport) timeNow() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// retryable reports whether a failed attempt is worth retrying.
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, 529:
+		return true
+	}
+	return false
+}
+
+func describeAttempt(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("status %d", resp.StatusCode)
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		return max(0, at.Sub(now)), true
+	}
+	return 0, false
+}
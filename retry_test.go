@@ -0,0 +1,184 @@
+package claudecodeproxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// failingUpstream fails the first len(statuses) requests with the given statuses and then
+// answers 200 with the request body echoed back.
+func failingUpstream(t *testing.T, statuses []int, header http.Header) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(calls.Add(1))
+		body, _ := io.ReadAll(r.Body)
+		if n <= len(statuses) {
+			for k, v := range header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(statuses[n-1])
+			io.WriteString(w, `{"error":{"message":"try again"}}`)
+			return
+		}
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+// newTestRetryTransport returns a RetryTransport that records its waits instead of sleeping.
+func newTestRetryTransport(maxRetries int, waits *[]time.Duration) *RetryTransport {
+	rt := NewRetryTransport(nil, maxRetries)
+	rt.BaseDelay = 100 * time.Millisecond
+	rt.MaxDelay = time.Second
+	rt.jitter = func(n int64) int64 { return n - 1 } // always the longest delay
+	rt.sleep = func(ctx context.Context, d time.Duration) error {
+		*waits = append(*waits, d)
+		return nil
+	}
+	return rt
+}
+
+func TestRetryTransport_RetriesWithBackoff(t *testing.T) {
+	srv, calls := failingUpstream(t, []int{503, 502, 429}, nil)
+	var waits []time.Duration
+	rt := newTestRetryTransport(3, &waits)
+	var retries int
+	rt.OnRetry = func(*http.Request) { retries++ }
+	client := &http.Client{Transport: rt}
+
+	resp, err := client.Post(srv.URL, "application/json", strings.NewReader(`{"n":1}`))
+	if err != nil {
+		t.Fatalf("Post error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Status mismatch: got %d, want 200", resp.StatusCode)
+	}
+	if string(body) != `{"n":1}` {
+		t.Errorf("Replayed body mismatch: got %q", body)
+	}
+	if calls.Load() != 4 {
+		t.Errorf("Call count mismatch: got %d, want 4", calls.Load())
+	}
+	if retries != 3 {
+		t.Errorf("OnRetry count mismatch: got %d, want 3", retries)
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond}
+	if !reflect.DeepEqual(waits, want) {
+		t.Errorf("Backoff mismatch: got %v, want %v", waits, want)
+	}
+}
+
+func TestRetryTransport_GivesUp(t *testing.T) {
+	srv, calls := failingUpstream(t, []int{500, 500, 500, 500}, nil)
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(2, &waits)}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Status mismatch: got %d, want 500", resp.StatusCode)
+	}
+	// The last failed response is returned intact.
+	if body, _ := io.ReadAll(resp.Body); !strings.Contains(string(body), "try again") {
+		t.Errorf("Body mismatch: got %q", body)
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Call count mismatch: got %d, want 3", calls.Load())
+	}
+}
+
+func TestRetryTransport_NotRetryable(t *testing.T) {
+	srv, calls := failingUpstream(t, []int{400}, nil)
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(3, &waits)}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || calls.Load() != 1 || len(waits) != 0 {
+		t.Errorf("Expected a single 400 attempt, got status %d after %d calls", resp.StatusCode, calls.Load())
+	}
+}
+
+func TestRetryTransport_RetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		wantStatus int
+		wantWaits  []time.Duration
+	}{
+		{"Seconds", "1", http.StatusOK, []time.Duration{time.Second}},
+		{"HTTPDate", "Sun, 06 Nov 1994 08:49:37 GMT", http.StatusOK, []time.Duration{0}},
+		{"TooLong", "120", http.StatusTooManyRequests, nil},
+		{"Invalid", "soon", http.StatusOK, []time.Duration{100 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := failingUpstream(t, []int{429}, http.Header{"Retry-After": {tt.retryAfter}})
+			var waits []time.Duration
+			rt := newTestRetryTransport(3, &waits)
+			rt.now = func() time.Time { return time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC) }
+			client := &http.Client{Transport: rt}
+
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Fatalf("Get error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Status mismatch: got %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if !reflect.DeepEqual(waits, tt.wantWaits) {
+				t.Errorf("Waits mismatch: got %v, want %v", waits, tt.wantWaits)
+			}
+		})
+	}
+}
+
+func TestRetryTransport_ConnectionError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close() // connections are refused from now on
+
+	var waits []time.Duration
+	client := &http.Client{Transport: newTestRetryTransport(2, &waits)}
+	if _, err := client.Get(url); err == nil {
+		t.Fatal("Expected a connection error")
+	}
+	if len(waits) != 2 {
+		t.Errorf("Retry count mismatch: got %d, want 2", len(waits))
+	}
+}
+
+func TestRetryTransport_ContextCancelled(t *testing.T) {
+	srv, calls := failingUpstream(t, []int{503, 503}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	rt := NewRetryTransport(nil, 3)
+	rt.sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if _, err := (&http.Client{Transport: rt}).Do(req); err == nil {
+		t.Fatal("Expected an error after cancellation")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("Call count mismatch: got %d, want 1", calls.Load())
+	}
+}
@@ -0,0 +1,245 @@
+package claudecodeproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// ModelRoute maps Claude model names to an upstream model.
+type ModelRoute struct {
+	// Match is an exact Claude model name or a glob pattern such as "claude-*-opus-*" or "*haiku*".
+	Match string `json:"match"`
+	// Upstream is the upstream model ID requests are sent to.
+	Upstream string `json:"upstream"`
+	// MaxTokens caps max_tokens for the route and is used when the client sends none. Zero means no cap.
+	MaxTokens int `json:"max_tokens,omitempty"`
+	// Parameters are merged into the upstream request body, overriding converted values.
+	Parameters map[string]any `json:"parameters,omitempty"`
+	// ThinkingUpstream is the reasoning model used instead of Upstream when the client asks for
+	// extended thinking. Empty uses RoutingConfig.ThinkingModel.
+	ThinkingUpstream string `json:"thinking_upstream,omitempty"`
+	// Backend selects the upstream API: BackendOpenAI (the default) or BackendAnthropic, which
+	// forwards requests unconverted. Anthropic routes may leave Upstream empty to keep the
+	// client's model name.
+	Backend string `json:"backend,omitempty"`
+
+	// reasoning is set for routes to a reasoning model, which keep reasoning_effort.
+	reasoning bool
+}
+
+// RoutingConfig is an ordered routing table. The first matching route wins; requests that match
+// no route are sent to DefaultModel.
+type RoutingConfig struct {
+	Routes       []ModelRoute `json:"routes"`
+	DefaultModel string       `json:"default_model"`
+	// ReportUpstreamModel reports the upstream model ID in the response "model" field
+	// instead of echoing the requested Claude model name.
+	ReportUpstreamModel bool `json:"report_upstream_model,omitempty"`
+	// ThinkingModel is the reasoning model for extended thinking requests whose route sets no
+	// thinking_upstream. When both are empty, thinking requests use the normal route and the
+	// thinking configuration is ignored.
+	ThinkingModel string `json:"thinking_model,omitempty"`
+}
+
+// DefaultRoutingConfig returns the built-in routing table: haiku models go to gpt-4o-mini,
+// extended thinking requests to o4-mini and everything else to gpt-4.1.
+func DefaultRoutingConfig() RoutingConfig {
+	return RoutingConfig{
+		Routes: []ModelRoute{
+			{Match: "*haiku*", Upstream: "gpt-4o-mini"},
+		},
+		DefaultModel:  "gpt-4.1",
+		ThinkingModel: "o4-mini",
+	}
+}
+
+// LoadRoutingConfig reads a JSON routing table from path.
+func LoadRoutingConfig(path string) (RoutingConfig, error) {
+	var cfg RoutingConfig
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("parse routing config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("routing config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that every route has an upstream model and a well-formed pattern.
+func (c RoutingConfig) Validate() error {
+	if c.DefaultModel == "" {
+		return fmt.Errorf("default_model is required")
+	}
+	for i, r := range c.Routes {
+		if r.Match == "" || (r.Upstream == "" && r.Backend != BackendAnthropic) {
+			return fmt.Errorf("route %d: match and upstream are required", i)
+		}
+		switch r.Backend {
+		case "", BackendOpenAI, BackendAnthropic:
+		default:
+			return fmt.Errorf("route %d: unknown backend %q", i, r.Backend)
+		}
+		if _, err := path.Match(r.Match, ""); err != nil {
+			return fmt.Errorf("route %d: bad pattern %q: %w", i, r.Match, err)
+		}
+		if r.MaxTokens < 0 {
+			return fmt.Errorf("route %d: max_tokens must not be negative", i)
+		}
+	}
+	return nil
+}
+
+// ParseRouteOverrides parses a comma-separated list of "pattern=upstream[:max_tokens]" entries,
+// e.g. "claude-*-opus-*=o3:32000,*haiku*=gpt-4o-mini".
+func ParseRouteOverrides(s string) ([]ModelRoute, error) {
+	var routes []ModelRoute
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		match, target, ok := strings.Cut(entry, "=")
+		if !ok || match == "" || target == "" {
+			return nil, fmt.Errorf("invalid route override %q", entry)
+		}
+		route := ModelRoute{Match: strings.TrimSpace(match), Upstream: strings.TrimSpace(target)}
+		if upstream, maxTokens, ok := strings.Cut(route.Upstream, ":"); ok {
+			n, err := strconv.Atoi(maxTokens)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid max_tokens in route override %q", entry)
+			}
+			route.Upstream = upstream
+			route.MaxTokens = n
+		}
+		if _, err := path.Match(route.Match, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern in route override %q: %w", entry, err)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// WithOverrides returns a copy of the config with routes taking precedence over the existing ones.
+func (c RoutingConfig) WithOverrides(routes []ModelRoute) RoutingConfig {
+	c.Routes = append(append([]ModelRoute{}, routes...), c.Routes...)
+	return c
+}
+
+// Route returns the route for a Claude model name.
+func (c RoutingConfig) Route(model string) ModelRoute {
+	for _, r := range c.Routes {
+		if r.Match == model {
+			return r
+		}
+		if ok, _ := path.Match(r.Match, model); ok {
+			return r
+		}
+	}
+	return ModelRoute{Match: model, Upstream: c.DefaultModel}
+}
+
+// Matches reports whether a route matches the model name.
+func (c RoutingConfig) Matches(model string) bool {
+	for _, r := range c.Routes {
+		if ok, _ := path.Match(r.Match, model); ok || r.Match == model {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelNames returns the model IDs clients can ask for: the exact (non-pattern) Claude names
+// of the routes and every upstream model, without duplicates.
+func (c RoutingConfig) ModelNames() []string {
+	var names []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, r := range c.Routes {
+		if !strings.ContainsAny(r.Match, "*?[\\") {
+			add(r.Match)
+		}
+	}
+	add(c.DefaultModel)
+	for _, r := range c.Routes {
+		add(r.Upstream)
+		add(r.ThinkingUpstream)
+	}
+	add(c.ThinkingModel)
+	return names
+}
+
+// RouteRequest returns the route for a request. Requests with extended thinking enabled are sent
+// to the route's ThinkingUpstream, or to ThinkingModel, unless the route goes to Anthropic, which
+// supports thinking itself.
+func (c RoutingConfig) RouteRequest(req ClaudeMessagesRequest) ModelRoute {
+	route := c.Route(req.Model)
+	if !req.Thinking.IsEnabled() || route.Backend == BackendAnthropic {
+		return route
+	}
+	upstream := route.ThinkingUpstream
+	if upstream == "" {
+		upstream = c.ThinkingModel
+	}
+	if upstream != "" {
+		route.Upstream = upstream
+		route.reasoning = true
+	}
+	return route
+}
+
+// isReasoningModel reports whether model is an OpenAI o-series reasoning model such as o3 or o4-mini.
+func isReasoningModel(model string) bool {
+	return len(model) >= 2 && model[0] == 'o' && model[1] >= '0' && model[1] <= '9'
+}
+
+// ResponseModel returns the model name reported back to the client for a request.
+func (c RoutingConfig) ResponseModel(req ClaudeMessagesRequest) string {
+	if c.ReportUpstreamModel {
+		return c.RouteRequest(req).Upstream
+	}
+	return req.Model
+}
+
+// Apply sets the upstream model on req and applies the route's max_tokens cap and parameter overrides.
+// reasoning_effort is dropped unless the route leads to a reasoning model, and the token limit is
+// sent as max_completion_tokens to reasoning models, which reject max_tokens.
+func (r ModelRoute) Apply(req *OAIRequest) {
+	req.Model = r.Upstream
+	reasoning := r.reasoning || isReasoningModel(r.Upstream)
+	if !reasoning {
+		req.ReasoningEffort = ""
+	}
+	limit := req.MaxTokens
+	if limit <= 0 {
+		limit = req.MaxCompletionTokens
+	}
+	if r.MaxTokens > 0 && (limit <= 0 || limit > r.MaxTokens) {
+		limit = r.MaxTokens
+	}
+	if reasoning {
+		req.MaxTokens, req.MaxCompletionTokens = 0, limit
+	} else {
+		req.MaxTokens, req.MaxCompletionTokens = limit, 0
+	}
+	if len(r.Parameters) > 0 {
+		if req.ExtraParams == nil {
+			req.ExtraParams = make(map[string]any, len(r.Parameters))
+		}
+		for k, v := range r.Parameters {
+			req.ExtraParams[k] = v
+		}
+	}
+}
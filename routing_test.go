@@ -0,0 +1,251 @@
+package claudecodeproxy
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRoutingConfig_Route(t *testing.T) {
+	cfg := RoutingConfig{
+		Routes: []ModelRoute{
+			{Match: "claude-3-7-sonnet-20250219", Upstream: "claude-3.7-sonnet"},
+			{Match: "claude-*-opus-*", Upstream: "o3", MaxTokens: 32000},
+			{Match: "*haiku*", Upstream: "gpt-4o-mini"},
+		},
+		DefaultModel: "gpt-4.1",
+	}
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"claude-3-7-sonnet-20250219", "claude-3.7-sonnet"},
+		{"claude-3-opus-20240229", "o3"},
+		{"claude-3-5-haiku-20241022", "gpt-4o-mini"},
+		{"claude-sonnet-4-20250514", "gpt-4.1"},
+	}
+	for _, tt := range tests {
+		if got := cfg.Route(tt.model).Upstream; got != tt.want {
+			t.Errorf("Route(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestModelRoute_Apply(t *testing.T) {
+	route := ModelRoute{
+		Upstream:   "o3",
+		MaxTokens:  1000,
+		Parameters: map[string]any{"temperature": 1.0, "reasoning_effort": "high"},
+	}
+	temp := 0.2
+	req := OAIRequest{Model: "gpt-4.1", MaxTokens: 4096, Temperature: &temp}
+	route.Apply(&req)
+	// o3 is a reasoning model, so the limit moves to max_completion_tokens.
+	if req.Model != "o3" || req.MaxCompletionTokens != 1000 || req.MaxTokens != 0 {
+		t.Errorf("Apply mismatch: model %q, max_completion_tokens %d, max_tokens %d", req.Model, req.MaxCompletionTokens, req.MaxTokens)
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatalf("Marshal error: %v", err)
+	}
+	var got map[string]any
+	json.Unmarshal(body, &got)
+	if got["temperature"] != 1.0 || got["reasoning_effort"] != "high" || got["model"] != "o3" {
+		t.Errorf("Parameter overrides not applied: %s", body)
+	}
+
+	// A request below the cap keeps its own max_tokens; one without max_tokens gets the cap.
+	req = OAIRequest{MaxTokens: 10}
+	route.Apply(&req)
+	if req.MaxCompletionTokens != 10 {
+		t.Errorf("Expected max_tokens 10 to be kept, got %d", req.MaxCompletionTokens)
+	}
+	req = OAIRequest{}
+	route.Apply(&req)
+	if req.MaxCompletionTokens != 1000 {
+		t.Errorf("Expected max_tokens to default to the cap, got %d", req.MaxCompletionTokens)
+	}
+}
+
+func TestModelRoute_ApplyReasoningMaxTokens(t *testing.T) {
+	cfg := RoutingConfig{
+		Routes:        []ModelRoute{{Match: "*opus*", Upstream: "gpt-4.1", MaxTokens: 8000}},
+		DefaultModel:  "gpt-4.1",
+		ThinkingModel: "o4-mini",
+	}
+	tests := []struct {
+		name string
+		req  ClaudeMessagesRequest
+		want map[string]any
+	}{
+		{
+			name: "Thinking",
+			req:  ClaudeMessagesRequest{Model: "claude-sonnet-4", MaxTokens: 16000, Thinking: &ClaudeThinkingConfig{Type: "enabled", BudgetTokens: 4000}},
+			want: map[string]any{"model": "o4-mini", "max_completion_tokens": 16000.0},
+		},
+		{
+			name: "ThinkingCapped",
+			req:  ClaudeMessagesRequest{Model: "claude-opus-4", MaxTokens: 16000, Thinking: &ClaudeThinkingConfig{Type: "enabled", BudgetTokens: 4000}},
+			want: map[string]any{"model": "o4-mini", "max_completion_tokens": 8000.0},
+		},
+		{
+			name: "NoThinking",
+			req:  ClaudeMessagesRequest{Model: "claude-opus-4", MaxTokens: 16000},
+			want: map[string]any{"model": "gpt-4.1", "max_tokens": 8000.0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := ConvertClaudeToOAI(tt.req)
+			if err != nil {
+				t.Fatalf("ConvertClaudeToOAI error: %v", err)
+			}
+			cfg.RouteRequest(tt.req).Apply(&req)
+			body, err := json.Marshal(req)
+			if err != nil {
+				t.Fatalf("Marshal error: %v", err)
+			}
+			var got map[string]any
+			json.Unmarshal(body, &got)
+			for _, key := range []string{"model", "max_tokens", "max_completion_tokens"} {
+				if got[key] != tt.want[key] {
+					t.Errorf("%s mismatch: got %v, want %v in %s", key, got[key], tt.want[key], body)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRouteOverrides(t *testing.T) {
+	got, err := ParseRouteOverrides("claude-*-opus-*=o3:32000, *haiku*=gpt-4o-mini")
+	if err != nil {
+		t.Fatalf("ParseRouteOverrides error: %v", err)
+	}
+	want := []ModelRoute{
+		{Match: "claude-*-opus-*", Upstream: "o3", MaxTokens: 32000},
+		{Match: "*haiku*", Upstream: "gpt-4o-mini"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseRouteOverrides = %+v, want %+v", got, want)
+	}
+
+	for _, bad := range []string{"nomatch", "a=b:notanumber", "[=gpt"} {
+		if _, err := ParseRouteOverrides(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoadRoutingConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	os.WriteFile(path, []byte(`{
+		"default_model": "gpt-4.1",
+		"report_upstream_model": true,
+		"routes": [{"match": "*opus*", "upstream": "o3", "max_tokens": 2000, "parameters": {"top_p": 0.5}}]
+	}`), 0o644)
+
+	cfg, err := LoadRoutingConfig(path)
+	if err != nil {
+		t.Fatalf("LoadRoutingConfig error: %v", err)
+	}
+	cfg = cfg.WithOverrides([]ModelRoute{{Match: "claude-3-opus-latest", Upstream: "gpt-4o"}})
+	if got := cfg.Route("claude-3-opus-latest").Upstream; got != "gpt-4o" {
+		t.Errorf("Override should take precedence, got %q", got)
+	}
+	if got := cfg.Route("claude-opus-4").MaxTokens; got != 2000 {
+		t.Errorf("Route max_tokens mismatch: got %d", got)
+	}
+	if got := cfg.ResponseModel(ClaudeMessagesRequest{Model: "claude-opus-4"}); got != "o3" {
+		t.Errorf("ResponseModel should report the upstream model, got %q", got)
+	}
+
+	os.WriteFile(path, []byte(`{"routes": [{"match": "*opus*"}], "default_model": "gpt-4.1"}`), 0o644)
+	if _, err := LoadRoutingConfig(path); err == nil {
+		t.Errorf("Expected an error for a route without upstream")
+	}
+}
+
+func TestRoutingConfig_RouteRequestThinking(t *testing.T) {
+	cfg := RoutingConfig{
+		Routes: []ModelRoute{
+			{Match: "*opus*", Upstream: "gpt-4.1", ThinkingUpstream: "o3"},
+			{Match: "*haiku*", Upstream: "gpt-4o-mini"},
+		},
+		DefaultModel:  "gpt-4.1",
+		ThinkingModel: "o4-mini",
+	}
+	thinking := &ClaudeThinkingConfig{Type: "enabled", BudgetTokens: 4000}
+	tests := []struct {
+		name       string
+		req        ClaudeMessagesRequest
+		want       string
+		wantEffort string
+	}{
+		{"RouteThinkingUpstream", ClaudeMessagesRequest{Model: "claude-opus-4", Thinking: thinking}, "o3", "low"},
+		{"ThinkingModel", ClaudeMessagesRequest{Model: "claude-3-5-haiku", Thinking: thinking}, "o4-mini", "low"},
+		{"Disabled", ClaudeMessagesRequest{Model: "claude-opus-4", Thinking: &ClaudeThinkingConfig{Type: "disabled"}}, "gpt-4.1", ""},
+		{"NoThinking", ClaudeMessagesRequest{Model: "claude-3-5-haiku"}, "gpt-4o-mini", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := OAIRequest{ReasoningEffort: "low"}
+			cfg.RouteRequest(tt.req).Apply(&req)
+			if req.Model != tt.want || req.ReasoningEffort != tt.wantEffort {
+				t.Errorf("Route mismatch: got %q/%q, want %q/%q", req.Model, req.ReasoningEffort, tt.want, tt.wantEffort)
+			}
+		})
+	}
+
+	// Without a thinking model, thinking requests stay on the normal route.
+	cfg.ThinkingModel = ""
+	req := OAIRequest{ReasoningEffort: "low"}
+	cfg.RouteRequest(ClaudeMessagesRequest{Model: "claude-3-5-haiku", Thinking: thinking}).Apply(&req)
+	if req.Model != "gpt-4o-mini" || req.ReasoningEffort != "" {
+		t.Errorf("Route mismatch without thinking model: got %q/%q", req.Model, req.ReasoningEffort)
+	}
+}
+
+func TestRoutingConfig_ModelNames(t *testing.T) {
+	cfg := RoutingConfig{
+		Routes: []ModelRoute{
+			{Match: "claude-3-7-sonnet-20250219", Upstream: "claude-3.7-sonnet"},
+			{Match: "*opus*", Upstream: "o3", ThinkingUpstream: "o3"},
+			{Match: "*haiku*", Upstream: "gpt-4.1"},
+		},
+		DefaultModel:  "gpt-4.1",
+		ThinkingModel: "o4-mini",
+	}
+	want := []string{"claude-3-7-sonnet-20250219", "gpt-4.1", "claude-3.7-sonnet", "o3", "o4-mini"}
+	if got := cfg.ModelNames(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ModelNames mismatch: got %v, want %v", got, want)
+	}
+	for model, want := range map[string]bool{"claude-3-opus-20240229": true, "claude-3-7-sonnet-20250219": true, "gpt-4.1": false} {
+		if got := cfg.Matches(model); got != want {
+			t.Errorf("Matches(%q) = %v, want %v", model, got, want)
+		}
+	}
+}
+
+func TestRoutingConfig_AnthropicBackend(t *testing.T) {
+	cfg := RoutingConfig{
+		Routes:        []ModelRoute{{Match: "*opus*", Backend: BackendAnthropic}},
+		DefaultModel:  "gpt-4.1",
+		ThinkingModel: "o4-mini",
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Anthropic routes may omit the upstream model: %v", err)
+	}
+	// Anthropic handles thinking itself, so the thinking model is not used.
+	req := ClaudeMessagesRequest{Model: "claude-opus-4", Thinking: &ClaudeThinkingConfig{Type: "enabled", BudgetTokens: 2048}}
+	if route := cfg.RouteRequest(req); route.Backend != BackendAnthropic || route.Upstream != "" {
+		t.Errorf("Route mismatch: got %+v", route)
+	}
+
+	cfg.Routes[0].Backend = "bedrock"
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected an error for an unknown backend")
+	}
+}
@@ -0,0 +1,193 @@
+package claudecodeproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// SSEEvent is a single Server-Sent Event.
+type SSEEvent struct {
+	Event string
+	Data  string
+}
+
+// SSEWriter writes Server-Sent Events frames ("event: <name>\ndata: <json>\n\n") to an
+// underlying writer, flushing after every event when the writer is an http.Flusher.
+// The first write error is sticky: later writes are skipped and the error is returned by Err.
+type SSEWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+	err     error
+}
+
+// NewSSEWriter returns an SSEWriter writing to w.
+func NewSSEWriter(w io.Writer) *SSEWriter {
+	flusher, _ := w.(http.Flusher)
+	return &SSEWriter{w: w, flusher: flusher}
+}
+
+// WriteEvent marshals data as JSON and writes it as a single event frame. An empty event name
+// writes a data-only frame, as used by OpenAI streams.
+func (s *SSEWriter) WriteEvent(event string, data any) error {
+	if s.err != nil {
+		return s.err
+	}
+	payload, err := json.Marshal(data)
+	if err != nil {
+		s.err = err
+		return err
+	}
+	if event == "" {
+		return s.WriteData(string(payload))
+	}
+	return s.write("event: %s\ndata: %s\n\n", event, payload)
+}
+
+// WriteData writes a data-only frame with data as is, e.g. OpenAI's "[DONE]" marker.
+func (s *SSEWriter) WriteData(data string) error {
+	return s.write("data: %s\n\n", data)
+}
+
+// Forward writes an event read by SSEReader unchanged.
+func (s *SSEWriter) Forward(ev SSEEvent) error {
+	var b strings.Builder
+	if ev.Event != "" {
+		b.WriteString("event: " + ev.Event + "\n")
+	}
+	for _, line := range strings.Split(ev.Data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write("%s", b.String())
+}
+
+// CopySSE copies events from r to w, flushing after each one, until r is exhausted.
+func CopySSE(w io.Writer, r io.Reader) error {
+	sse := NewSSEWriter(w)
+	reader := NewSSEReader(r)
+	for {
+		ev, err := reader.ReadEvent()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := sse.Forward(ev); err != nil {
+			return err
+		}
+	}
+}
+
+// WatchSSE returns a reader passing r through unchanged that calls fn with every complete event
+// read from it.
+func WatchSSE(r io.ReadCloser, fn func(SSEEvent)) io.ReadCloser {
+	return &sseWatcher{ReadCloser: r, fn: fn}
+}
+
+type sseWatcher struct {
+	io.ReadCloser
+	fn  func(SSEEvent)
+	buf []byte
+}
+
+func (w *sseWatcher) Read(p []byte) (int, error) {
+	n, err := w.ReadCloser.Read(p)
+	// Only the events matter here, so CRs are dropped to find frame ends as blank lines.
+	w.buf = append(w.buf, bytes.ReplaceAll(p[:n], []byte("\r"), nil)...)
+	for {
+		i := bytes.Index(w.buf, []byte("\n\n"))
+		if i < 0 && (err == nil || len(w.buf) == 0) {
+			break
+		}
+		frame := w.buf
+		if i >= 0 {
+			frame, w.buf = w.buf[:i+2], w.buf[i+2:]
+		} else {
+			w.buf = nil
+		}
+		if ev, err := NewSSEReader(bytes.NewReader(frame)).ReadEvent(); err == nil {
+			w.fn(ev)
+		}
+	}
+	return n, err
+}
+
+func (s *SSEWriter) write(format string, args ...any) error {
+	if s.err != nil {
+		return s.err
+	}
+	if _, err := fmt.Fprintf(s.w, format, args...); err != nil {
+		s.err = err
+		return err
+	}
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+	return nil
+}
+
+// Err returns the first error encountered while writing, if any.
+func (s *SSEWriter) Err() error {
+	return s.err
+}
+
+// SSEReader parses Server-Sent Events from an underlying reader.
+type SSEReader struct {
+	r *bufio.Reader
+}
+
+// NewSSEReader returns an SSEReader reading from r.
+func NewSSEReader(r io.Reader) *SSEReader {
+	return &SSEReader{r: bufio.NewReader(r)}
+}
+
+// ReadEvent returns the next event in the stream. Multiple data lines are joined with
+// newlines, comment lines are ignored and a trailing event without a terminating blank
+// line is still returned. It returns io.EOF once the stream is exhausted.
+func (s *SSEReader) ReadEvent() (SSEEvent, error) {
+	var ev SSEEvent
+	var data []string
+	hasData := false
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return SSEEvent{}, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if hasData {
+				ev.Data = strings.Join(data, "\n")
+				return ev, nil
+			}
+			if err == io.EOF {
+				return SSEEvent{}, io.EOF
+			}
+			ev = SSEEvent{}
+			continue
+		}
+		if !strings.HasPrefix(line, ":") {
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch field {
+			case "event":
+				ev.Event = value
+			case "data":
+				data = append(data, value)
+				hasData = true
+			}
+		}
+		if err == io.EOF {
+			if hasData {
+				ev.Data = strings.Join(data, "\n")
+				return ev, nil
+			}
+			return SSEEvent{}, io.EOF
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package claudecodeproxy
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSSEWriter_Framing(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sse := NewSSEWriter(rec)
+	if err := sse.WriteEvent("ping", map[string]any{"type": "ping"}); err != nil {
+		t.Fatalf("WriteEvent error: %v", err)
+	}
+	want := "event: ping\ndata: {\"type\":\"ping\"}\n\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("Frame mismatch: got %q, want %q", got, want)
+	}
+	if !rec.Flushed {
+		t.Errorf("Expected SSEWriter to flush after writing an event")
+	}
+}
+
+type failingWriter struct{ writes int }
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	f.writes++
+	return 0, io.ErrClosedPipe
+}
+
+func TestSSEWriter_StickyError(t *testing.T) {
+	fw := &failingWriter{}
+	sse := NewSSEWriter(fw)
+	if err := sse.WriteEvent("ping", map[string]any{"type": "ping"}); err != io.ErrClosedPipe {
+		t.Fatalf("Expected ErrClosedPipe, got %v", err)
+	}
+	sse.WriteEvent("ping", map[string]any{"type": "ping"})
+	if fw.writes != 1 {
+		t.Errorf("Expected writes to stop after the first error, got %d writes", fw.writes)
+	}
+	if sse.Err() != io.ErrClosedPipe {
+		t.Errorf("Err() mismatch: got %v", sse.Err())
+	}
+}
+
+func TestSSEReader_ReadEvent(t *testing.T) {
+	stream := ": comment\r\n" +
+		"event: message_start\r\n" +
+		"data: {\"a\":1}\r\n" +
+		"\r\n" +
+		"data: line1\n" +
+		"data:line2\n" +
+		"\n" +
+		"event: message_stop\n" +
+		"data: {}"
+	reader := NewSSEReader(strings.NewReader(stream))
+
+	want := []SSEEvent{
+		{Event: "message_start", Data: `{"a":1}`},
+		{Event: "", Data: "line1\nline2"},
+		{Event: "message_stop", Data: "{}"},
+	}
+	for i, w := range want {
+		ev, err := reader.ReadEvent()
+		if err != nil {
+			t.Fatalf("event %d: ReadEvent error: %v", i, err)
+		}
+		if ev != w {
+			t.Errorf("event %d mismatch: got %+v, want %+v", i, ev, w)
+		}
+	}
+	if _, err := reader.ReadEvent(); err != io.EOF {
+		t.Errorf("Expected io.EOF at end of stream, got %v", err)
+	}
+}
+
+func TestConvertOAIStreamToClaudeStream_SSEFraming(t *testing.T) {
+	oaiStream := `
+data: {"id":"cmpl-abc","object":"chat.completion.chunk","created":123,"model":"gpt-4o","choices":[{"delta":{"content":"Hi"},"finish_reason":"stop"}]}
+data: [DONE]
+`
+	rec := httptest.NewRecorder()
+	if err := ConvertOAIStreamToClaudeStream(strings.NewReader(oaiStream), rec, "claude-3-sonnet-20240229"); err != nil {
+		t.Fatalf("ConvertOAIStreamToClaudeStream error: %v", err)
+	}
+	out := rec.Body.String()
+	if strings.Contains(out, "[DONE]") {
+		t.Errorf("Output must not contain an OpenAI [DONE] marker, got: %s", out)
+	}
+	reader := NewSSEReader(strings.NewReader(out))
+	var events []string
+	for {
+		ev, err := reader.ReadEvent()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadEvent error: %v", err)
+		}
+		if !strings.Contains(ev.Data, `"type":"`+ev.Event+`"`) {
+			t.Errorf("Event %q data does not carry a matching type: %s", ev.Event, ev.Data)
+		}
+		events = append(events, ev.Event)
+	}
+	if len(events) == 0 || events[0] != "message_start" || events[len(events)-1] != "message_stop" {
+		t.Errorf("Unexpected event sequence: %v", events)
+	}
+	if !rec.Flushed {
+		t.Errorf("Expected stream to be flushed")
+	}
+}
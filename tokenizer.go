@@ -0,0 +1,361 @@
+package claudecodeproxy
+
+import (
+	"bufio"
+	"embed"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tokenizer counts the tokens in a piece of text.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// BPETokenizer is a byte-level BPE tokenizer compatible with tiktoken rank files
+// (one "<base64 token> <rank>" pair per line, e.g. cl100k_base.tiktoken).
+// Text is split with the cl100k pre-tokenization rules before merging.
+type BPETokenizer struct {
+	ranks map[string]int
+}
+
+// NewBPETokenizer builds a tokenizer from a map of byte sequences to merge ranks.
+func NewBPETokenizer(ranks map[string]int) *BPETokenizer {
+	return &BPETokenizer{ranks: ranks}
+}
+
+// LoadBPETokenizer reads a tiktoken rank file from r.
+func LoadBPETokenizer(r io.Reader) (*BPETokenizer, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		token, rankStr, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("rank file line %d: missing rank", lineNo)
+		}
+		b, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("rank file line %d: %w", lineNo, err)
+		}
+		rank, err := strconv.Atoi(rankStr)
+		if err != nil {
+			return nil, fmt.Errorf("rank file line %d: %w", lineNo, err)
+		}
+		ranks[string(b)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("rank file is empty")
+	}
+	return NewBPETokenizer(ranks), nil
+}
+
+// LoadBPETokenizerFile reads a tiktoken rank file from disk.
+func LoadBPETokenizerFile(path string) (*BPETokenizer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadBPETokenizer(f)
+}
+
+//go:generate sh -c "curl -fsSL https://openaipublic.blob.core.windows.net/encodings/cl100k_base.tiktoken -o vocab/cl100k_base.tiktoken && echo '223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7  vocab/cl100k_base.tiktoken' | sha256sum -c"
+
+// vocab holds the rank files built into the binary, fetched by go generate.
+//
+//go:embed vocab
+var vocab embed.FS
+
+// cl100kRankFile is the path of the cl100k_base rank file in vocab.
+const cl100kRankFile = "vocab/cl100k_base.tiktoken"
+
+var loadCl100k = sync.OnceValues(func() (*BPETokenizer, error) {
+	f, err := vocab.Open(cl100kRankFile)
+	if err != nil {
+		return nil, fmt.Errorf("cl100k_base rank file not built in, run go generate: %w", err)
+	}
+	defer f.Close()
+	return LoadBPETokenizer(f)
+})
+
+// Cl100kTokenizer returns the tokenizer of the cl100k_base rank file built into the binary,
+// loading it on first use.
+func Cl100kTokenizer() (*BPETokenizer, error) {
+	return loadCl100k()
+}
+
+// Encode returns the token ranks for text.
+func (t *BPETokenizer) Encode(text string) []int {
+	var tokens []int
+	for _, piece := range splitPretokens(text) {
+		if rank, ok := t.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		tokens = append(tokens, t.bytePairEncode(piece)...)
+	}
+	return tokens
+}
+
+// CountTokens returns the number of tokens in text.
+func (t *BPETokenizer) CountTokens(text string) int {
+	return len(t.Encode(text))
+}
+
+// bytePairEncode repeatedly merges the adjacent pair with the lowest rank until no
+// mergeable pair is left, mirroring tiktoken's byte_pair_merge.
+func (t *BPETokenizer) bytePairEncode(piece string) []int {
+	// parts[i] is the start offset of the i-th part; the last entry marks the end.
+	parts := make([]int, len(piece)+1)
+	for i := range parts {
+		parts[i] = i
+	}
+	rankOf := func(i int) int {
+		if i+2 >= len(parts) {
+			return math.MaxInt
+		}
+		if rank, ok := t.ranks[piece[parts[i]:parts[i+2]]]; ok {
+			return rank
+		}
+		return math.MaxInt
+	}
+	for len(parts) > 2 {
+		minRank, minIdx := math.MaxInt, -1
+		for i := 0; i < len(parts)-2; i++ {
+			if rank := rankOf(i); rank < minRank {
+				minRank, minIdx = rank, i
+			}
+		}
+		if minIdx < 0 {
+			break
+		}
+		parts = append(parts[:minIdx+1], parts[minIdx+2:]...)
+	}
+
+	tokens := make([]int, 0, len(parts)-1)
+	for i := 0; i < len(parts)-1; i++ {
+		if rank, ok := t.ranks[piece[parts[i]:parts[i+1]]]; ok {
+			tokens = append(tokens, rank)
+		} else {
+			// Unknown byte; a complete rank file always covers every single byte.
+			tokens = append(tokens, -1)
+		}
+	}
+	return tokens
+}
+
+// EstimatingTokenizer approximates token counts without a vocabulary. It uses the
+// same pre-tokenization as BPETokenizer and assumes roughly six ASCII bytes per
+// token and one token per non-ASCII character within each piece.
+type EstimatingTokenizer struct{}
+
+// CountTokens returns an estimated number of tokens in text.
+func (EstimatingTokenizer) CountTokens(text string) int {
+	total := 0
+	for _, piece := range splitPretokens(text) {
+		ascii, other := 0, 0
+		for _, r := range piece {
+			if r < utf8.RuneSelf {
+				ascii++
+			} else {
+				other++
+			}
+		}
+		n := (ascii+5)/6 + other
+		if n == 0 {
+			n = 1
+		}
+		total += n
+	}
+	return total
+}
+
+// splitPretokens splits text following the cl100k_base pre-tokenization pattern:
+//
+//	(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+
+//
+// Go's regexp package has no lookahead, so the alternation is evaluated by hand.
+func splitPretokens(text string) []string {
+	runes := []rune(text)
+	var pieces []string
+	for i := 0; i < len(runes); {
+		n := matchPretoken(runes, i)
+		pieces = append(pieces, string(runes[i:i+n]))
+		i += n
+	}
+	return pieces
+}
+
+func isNewline(r rune) bool { return r == '\r' || r == '\n' }
+
+func isPunct(r rune) bool {
+	return !unicode.IsSpace(r) && !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// matchPretoken returns the length in runes of the pre-token starting at runes[i].
+func matchPretoken(runes []rune, i int) int {
+	n := len(runes)
+	at := func(j int) rune {
+		if j < n {
+			return runes[j]
+		}
+		return 0
+	}
+	r0 := runes[i]
+
+	// (?i:'s|'t|'re|'ve|'m|'ll|'d)
+	if r0 == '\'' && i+1 < n {
+		switch unicode.ToLower(at(i + 1)) {
+		case 's', 't', 'm', 'd':
+			return 2
+		case 'r', 'v':
+			if unicode.ToLower(at(i+2)) == 'e' {
+				return 3
+			}
+		case 'l':
+			if unicode.ToLower(at(i+2)) == 'l' {
+				return 3
+			}
+		}
+	}
+
+	// [^\r\n\p{L}\p{N}]?\p{L}+
+	start := i
+	if !isNewline(r0) && !unicode.IsLetter(r0) && !unicode.IsNumber(r0) && i+1 < n && unicode.IsLetter(runes[i+1]) {
+		start = i + 1
+	}
+	if start < n && unicode.IsLetter(runes[start]) {
+		j := start
+		for j < n && unicode.IsLetter(runes[j]) {
+			j++
+		}
+		return j - i
+	}
+
+	// \p{N}{1,3}
+	if unicode.IsNumber(r0) {
+		j := i
+		for j < n && j-i < 3 && unicode.IsNumber(runes[j]) {
+			j++
+		}
+		return j - i
+	}
+
+	// ' ?[^\s\p{L}\p{N}]+[\r\n]*'
+	start = i
+	if r0 == ' ' && i+1 < n && isPunct(runes[i+1]) {
+		start = i + 1
+	}
+	if start < n && isPunct(runes[start]) {
+		j := start
+		for j < n && isPunct(runes[j]) {
+			j++
+		}
+		for j < n && isNewline(runes[j]) {
+			j++
+		}
+		return j - i
+	}
+
+	// Remaining alternatives all start with whitespace.
+	end := i
+	for end < n && unicode.IsSpace(runes[end]) {
+		end++
+	}
+	if end == i {
+		return 1
+	}
+
+	// \s*[\r\n]+ — greedy whitespace, backtracking to the last newline in the run.
+	for j := end - 1; j >= i; j-- {
+		if isNewline(runes[j]) {
+			return j + 1 - i
+		}
+	}
+
+	// \s+(?!\S) — whitespace up to the end of text, or all but the last space before a non-space.
+	if end == n {
+		return end - i
+	}
+	if end-i > 1 {
+		return end - 1 - i
+	}
+
+	// \s+
+	return end - i
+}
+
+// imageTokenEstimate is the token cost assumed for each image part, roughly what a
+// high-detail 1024x1024 image costs upstream.
+const imageTokenEstimate = 765
+
+// CountOAIRequestTokens counts the prompt tokens of an OAI request using the
+// chat message framing overhead documented by OpenAI: three tokens per message,
+// one extra token for a tool call id, and three tokens priming the reply.
+func CountOAIRequestTokens(req OAIRequest, tok Tokenizer) int {
+	total := 0
+	for _, m := range req.Messages {
+		total += 3
+		total += tok.CountTokens(m.Role)
+		for _, c := range m.Content {
+			if c.Type == "image_url" {
+				total += imageTokenEstimate
+				continue
+			}
+			total += tok.CountTokens(c.Text)
+		}
+		for _, tc := range m.ToolCalls {
+			total += 3
+			total += tok.CountTokens(tc.Function.Name)
+			total += tok.CountTokens(tc.Function.Arguments)
+		}
+		if m.ToolCallID != "" {
+			total += 1 + tok.CountTokens(m.ToolCallID)
+		}
+	}
+	if req.Tools != nil {
+		for _, t := range *req.Tools {
+			b, _ := json.Marshal(t.Function)
+			total += tok.CountTokens(string(b))
+		}
+	}
+	return total + 3
+}
+
+// CountClaudeTokens counts the input tokens of a Claude token count request by converting it
+// with ConvertClaudeToOAI, so the system prompt, tool schemas and tool results are counted
+// exactly as they will be sent upstream.
+func CountClaudeTokens(req ClaudeTokenCountRequest, tok Tokenizer) (int, error) {
+	oaiReq, err := ConvertClaudeToOAI(ClaudeMessagesRequest{
+		Model:         req.Model,
+		Messages:      req.Messages,
+		System:        req.System,
+		Tools:         req.Tools,
+		Thinking:      req.Thinking,
+		ToolChoice:    req.ToolChoice,
+		OriginalModel: req.OriginalModel,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return CountOAIRequestTokens(oaiReq, tok), nil
+}
@@ -0,0 +1,168 @@
+package claudecodeproxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRanks returns a tiny rank table: every single byte plus a handful of merges
+// building "hello" and " world".
+func testRanks() map[string]int {
+	ranks := make(map[string]int)
+	for b := 0; b < 256; b++ {
+		ranks[string([]byte{byte(b)})] = b
+	}
+	for i, merged := range []string{"he", "ll", "hell", "hello", " w", "or", " wor", "ld", " world"} {
+		ranks[merged] = 256 + i
+	}
+	return ranks
+}
+
+func TestSplitPretokens(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []string
+	}{
+		{"Hello world", []string{"Hello", " world"}},
+		{"I'm here", []string{"I", "'m", " here"}},
+		{"They'LL go", []string{"They", "'LL", " go"}},
+		{"12345", []string{"123", "45"}},
+		{"a  b", []string{"a", " ", " b"}},
+		{"foo!\n\nbar", []string{"foo", "!\n\n", "bar"}},
+		{"x  \n  y", []string{"x", "  \n", " ", " y"}},
+		{"trailing   ", []string{"trailing", "   "}},
+		{"hello, world", []string{"hello", ",", " world"}},
+		{"café déjà", []string{"café", " déjà"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := splitPretokens(tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitPretokens(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBPETokenizer_Encode(t *testing.T) {
+	tok := NewBPETokenizer(testRanks())
+	tests := []struct {
+		input string
+		want  []int
+	}{
+		{"hello world", []int{259, 264}},
+		{"hellold", []int{259, 263}},
+		{"hi", []int{'h', 'i'}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got := tok.Encode(tt.input)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Encode(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if n := tok.CountTokens(tt.input); n != len(tt.want) {
+			t.Errorf("CountTokens(%q) = %d, want %d", tt.input, n, len(tt.want))
+		}
+	}
+}
+
+func TestLoadBPETokenizer(t *testing.T) {
+	var sb strings.Builder
+	for token, rank := range testRanks() {
+		fmt.Fprintf(&sb, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+	tok, err := LoadBPETokenizer(strings.NewReader(sb.String()))
+	if err != nil {
+		t.Fatalf("LoadBPETokenizer error: %v", err)
+	}
+	if got := tok.Encode("hello world"); !reflect.DeepEqual(got, []int{259, 264}) {
+		t.Errorf("Encode mismatch after load: got %v", got)
+	}
+
+	if _, err := LoadBPETokenizer(strings.NewReader("aGVsbG8=\n")); err == nil {
+		t.Errorf("Expected an error for a line without a rank")
+	}
+}
+
+// TestBPETokenizer_Cl100kKnownCounts checks counts of the built-in cl100k_base vocabulary
+// against tiktoken's.
+func TestBPETokenizer_Cl100kKnownCounts(t *testing.T) {
+	tok, err := Cl100kTokenizer()
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		input string
+		want  int
+	}{
+		{"hello world", 2},
+		{"tiktoken is great!", 6},
+		{"お誕生日おめでとう", 9},
+	}
+	for _, tt := range tests {
+		if got := tok.CountTokens(tt.input); got != tt.want {
+			t.Errorf("CountTokens(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestEstimatingTokenizer(t *testing.T) {
+	var tok EstimatingTokenizer
+	if got := tok.CountTokens(""); got != 0 {
+		t.Errorf("Empty text should have no tokens, got %d", got)
+	}
+	if got := tok.CountTokens("hello world"); got != 2 {
+		t.Errorf("CountTokens(hello world) = %d, want 2", got)
+	}
+	if short, long := tok.CountTokens("word"), tok.CountTokens(strings.Repeat("word ", 100)); long <= short {
+		t.Errorf("Longer text should have more tokens: %d <= %d", long, short)
+	}
+}
+
+func TestCountClaudeTokens(t *testing.T) {
+	tok := NewBPETokenizer(testRanks())
+	desc := "Run a command"
+	base := ClaudeTokenCountRequest{
+		Model:    "claude-3-sonnet-20240229",
+		Messages: []ClaudeMessage{{Role: "user", Content: "hello world"}},
+	}
+
+	got, err := CountClaudeTokens(base, tok)
+	if err != nil {
+		t.Fatalf("CountClaudeTokens error: %v", err)
+	}
+	// 3 framing + "user" (4 bytes) + "hello world" (2) + 3 reply priming
+	if want := 3 + 4 + 2 + 3; got != want {
+		t.Errorf("CountClaudeTokens = %d, want %d", got, want)
+	}
+
+	withSystem := base
+	withSystem.System = []any{map[string]any{"type": "text", "text": "hello", "cache_control": map[string]any{"type": "ephemeral"}}}
+	got, _ = CountClaudeTokens(withSystem, tok)
+	// adds 3 framing + "system" (6 bytes) + "hello" (1)
+	if want := 12 + 3 + 6 + 1; got != want {
+		t.Errorf("CountClaudeTokens with system = %d, want %d", got, want)
+	}
+
+	withTools := base
+	withTools.Tools = &[]ClaudeTool{{Name: "Bash", Description: &desc, InputSchema: map[string]any{"type": "object"}}}
+	got, _ = CountClaudeTokens(withTools, tok)
+	if got <= 12 {
+		t.Errorf("Tool schemas should be counted, got %d", got)
+	}
+
+	withResult := base
+	withResult.Messages = append(withResult.Messages,
+		ClaudeMessage{Role: "assistant", Content: []any{map[string]any{"type": "tool_use", "id": "t", "name": "Bash", "input": map[string]any{}}}},
+		ClaudeMessage{Role: "user", Content: []any{map[string]any{"type": "tool_result", "tool_use_id": "t", "content": "hello world"}}},
+	)
+	got, _ = CountClaudeTokens(withResult, tok)
+	// assistant: 3 + "assistant" (9) + call 3 + "Bash" (4) + "{}" (2)
+	// tool: 3 + "tool" (4) + "hello world" (2) + id 1 + "t" (1)
+	if want := 12 + 3 + 9 + 3 + 4 + 2 + 3 + 4 + 2 + 1 + 1; got != want {
+		t.Errorf("CountClaudeTokens with tool result = %d, want %d", got, want)
+	}
+}